@@ -1,8 +1,9 @@
 // Package datapath implements linux data path for PPPoE/PPP;
 //
-//	TODO: currently datapath does NOT do following:
-//		- create default route with nexthop as the TUN interface
-//		- apply DNS server address
+// It creates a TUN interface per session, installs the address(es)
+// negotiated by IPCP/IPv6CP on it, and optionally installs a default (or
+// split-tunnel) route via the interface and applies the DNS/NBNS servers
+// IPCP negotiated, see RouteConfig and DNSApplier.
 package datapath
 
 import (
@@ -13,15 +14,43 @@ import (
 	"github.com/songgao/water"
 	"github.com/vishvananda/netlink"
 	"net"
+	"sync"
+	"time"
 )
 
 // TUNInterface is the TUN interface for a opened PPP session
 type TUNInterface struct {
 	logger                 *zerolog.Logger
+	ifName                 string
 	netInterface           *water.Interface
 	netLink                netlink.Link
-	sendChan               chan []byte
+	rawChan                chan rawFrame
+	sendBatchChan          chan [][]byte
 	v4recvChan, v6recvChan chan []byte
+	bufPool                *sync.Pool
+	batchSize              int
+	coalesceWindow         time.Duration
+	routeCfg               RouteConfig
+	installedRoutes        []*netlink.Route
+	dnsApplier             DNSApplier
+	dnsConfig              lcp.DNSConfig
+}
+
+// AddrConfig pairs a TUN interface's local address with the peer address
+// assigned to the far end of a point-to-point session, for one IP family.
+// Peer may be left nil, in which case only Local is installed, as before.
+type AddrConfig struct {
+	// Local is the address IPCP/IPv6CP assigned to this end of the session
+	Local net.IP
+	// Peer is the address assigned to the far end of the session
+	Peer net.IP
+}
+
+// rawFrame is an unparsed frame read from the TUN interface, along with the
+// pooled buffer it lives in so the buffer can be returned once encoded.
+type rawFrame struct {
+	buf []byte
+	n   int
 }
 
 const (
@@ -31,18 +60,90 @@ const (
 
 	// _defaultMaxFrameSize is the default max PPP frame size could be received from the TUN interface
 	_defaultMaxFrameSize = 1500
+
+	// _defaultBatchSize is the default max number of frames coalesced into
+	// a single batch handed to lcp.PPP per iteration of send
+	_defaultBatchSize = 64
+
+	// _defaultCoalesceWindow is how long send waits for more frames to
+	// arrive before issuing a partial batch
+	_defaultCoalesceWindow = 50 * time.Microsecond
+
+	// _rawChanDepth is the depth of the channel between the TUN reader
+	// goroutine and the batching/send goroutine
+	_rawChanDepth = 256
 )
 
+// Modifier is a function to provide custom configuration when creating a new TUNInterface
+type Modifier func(tun *TUNInterface)
+
+// WithBatchSize overrides the default max number of frames coalesced into a
+// single batch per send iteration; n must be > 0.
+func WithBatchSize(n int) Modifier {
+	return func(tun *TUNInterface) {
+		if n > 0 {
+			tun.batchSize = n
+		}
+	}
+}
+
+// WithCoalesceWindow overrides the default time send waits for more frames
+// to coalesce into the current batch before giving up and sending what it has.
+func WithCoalesceWindow(d time.Duration) Modifier {
+	return func(tun *TUNInterface) {
+		if d > 0 {
+			tun.coalesceWindow = d
+		}
+	}
+}
+
+// WithRouteConfig has NewTUNIf install routes via the TUN interface, see RouteConfig.
+func WithRouteConfig(cfg RouteConfig) Modifier {
+	return func(tun *TUNInterface) {
+		tun.routeCfg = cfg
+	}
+}
+
+// WithDNS has NewTUNIf apply the DNS/NBNS servers in cfg via applier once
+// the interface is up, and reverse it on teardown.
+func WithDNS(applier DNSApplier, cfg lcp.DNSConfig) Modifier {
+	return func(tun *TUNInterface) {
+		tun.dnsApplier = applier
+		tun.dnsConfig = cfg
+	}
+}
+
 // NewTUNIf creates a new TUN interface supporting PPP protocol.
-// The interface name must be specified in the parameters, and all the assigned addresses
-// are copied into the TUN interface.
-// MTU value is the value of peerMRU parameter.
-func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, assignedAddrs []net.IP, peerMRU uint16) (tun *TUNInterface, err error) {
+// The interface name must be specified in the parameters; v4Addr/v6Addr
+// carry the local and (if known) peer addresses negotiated by IPCP/IPv6CP
+// for each IP family, either of which may be left at its zero value to skip
+// that family. The interface MTU is set to peerMRU, raised to 1280 when
+// peerMRU is lower.
+//
+// I/O between the TUN interface and lcp.PPP is batched, but only on the
+// write side: readLoop still reads one frame per netInterface.Read syscall
+// (see its doc comment for why), it's only send/writeOutBatch downstream of
+// it that coalesces frames into a single Transport call.
+func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, v4Addr, v6Addr AddrConfig, peerMRU uint16, options ...Modifier) (tun *TUNInterface, err error) {
 	tun = new(TUNInterface)
+	tun.ifName = name
+	tun.batchSize = _defaultBatchSize
+	tun.coalesceWindow = _defaultCoalesceWindow
+	for _, option := range options {
+		option(tun)
+	}
+	tun.rawChan = make(chan rawFrame, _rawChanDepth)
+	tun.bufPool = &sync.Pool{
+		New: func() any {
+			return make([]byte, _defaultMaxFrameSize)
+		},
+	}
+
 	cfg := water.Config{
 		DeviceType: water.TUN,
 		PlatformSpecificParams: water.PlatformSpecificParams{
-			Name: name,
+			Name:       name,
+			MultiQueue: true,
 		},
 	}
 
@@ -58,32 +159,17 @@ func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, assignedAddrs
 		return nil, fmt.Errorf("failed to bring the TUN interface %v up, %w", cfg.Name, err)
 	}
 
-	// Add remote address
-	for _, addr := range assignedAddrs {
-		if addr == nil {
-			continue
+	// Add local address, and peer address if known
+	if isAssigned(v4Addr.Local) {
+		tun.sendBatchChan, tun.v4recvChan = pppproto.RegisterBatch(lcp.ProtoIPv4)
+		if err := tun.installAddr(v4Addr, "/32"); err != nil {
+			return nil, err
 		}
-		if !addr.IsUnspecified() && len(addr) > 0 {
-			var addressMask string
-			if addr.To4() != nil {
-				addressMask = "/32"
-				tun.sendChan, tun.v4recvChan = pppproto.Register(lcp.ProtoIPv4)
-			} else {
-				addressMask = "/128"
-				tun.sendChan, tun.v6recvChan = pppproto.Register(lcp.ProtoIPv6)
-			}
-
-			addrString := addr.String() + addressMask
-			netAddr, err := netlink.ParseAddr(addrString)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse %v as IP addr, %w", addrString, err)
-			}
-
-			// Add default remote route to the interface
-			err = netlink.AddrAdd(tun.netLink, netAddr)
-			if err != nil {
-				return nil, fmt.Errorf("failed to add addr %v, %w", addrString, err)
-			}
+	}
+	if isAssigned(v6Addr.Local) {
+		tun.sendBatchChan, tun.v6recvChan = pppproto.RegisterBatch(lcp.ProtoIPv6)
+		if err := tun.installAddr(v6Addr, "/128"); err != nil {
+			return nil, err
 		}
 	}
 
@@ -94,55 +180,214 @@ func NewTUNIf(ctx context.Context, pppproto *lcp.PPP, name string, assignedAddrs
 	}
 	_ = netlink.LinkSetMTU(tun.netLink, mtu)
 
+	if err := tun.installRoutes(v4Addr, v6Addr); err != nil {
+		return nil, err
+	}
+
+	if tun.dnsApplier != nil {
+		if err := tun.dnsApplier.Apply(name, tun.dnsConfig); err != nil {
+			return nil, fmt.Errorf("failed to apply DNS config, %w", err)
+		}
+	}
+
 	logger := pppproto.GetLogger().With().Str("Name", "datapath").Logger()
 	tun.logger = &logger
+	go tun.readLoop(ctx)
 	go tun.send(ctx)
 	go tun.recv(ctx)
+	go tun.teardownOnDone(ctx)
 	return tun, nil
 }
 
-// send pkt to outside network
-func (tif *TUNInterface) send(ctx context.Context) {
+// isAssigned reports whether addr is a real, specified IP address.
+func isAssigned(addr net.IP) bool {
+	return addr != nil && len(addr) > 0 && !addr.IsUnspecified()
+}
+
+// installAddr adds cfg.Local (with the given CIDR mask) to the interface,
+// set up as a point-to-point peer of cfg.Peer when known.
+func (tif *TUNInterface) installAddr(cfg AddrConfig, mask string) error {
+	addrString := cfg.Local.String() + mask
+	netAddr, err := netlink.ParseAddr(addrString)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v as IP addr, %w", addrString, err)
+	}
+
+	if isAssigned(cfg.Peer) {
+		peerMask := "/32"
+		if cfg.Peer.To4() == nil {
+			peerMask = "/128"
+		}
+		if peerNet, err := netlink.ParseIPNet(cfg.Peer.String() + peerMask); err == nil {
+			netAddr.Peer = peerNet
+		}
+	}
+
+	if err := netlink.AddrAdd(tif.netLink, netAddr); err != nil {
+		return fmt.Errorf("failed to add addr %v, %w", addrString, err)
+	}
+	return nil
+}
+
+// installRoutes installs the default and/or split-tunnel routes configured
+// via WithRouteConfig, scoped to whichever IP families got an address.
+func (tif *TUNInterface) installRoutes(v4Addr, v6Addr AddrConfig) error {
+	if tif.routeCfg.DefaultRoute {
+		if isAssigned(v4Addr.Local) {
+			if err := tif.addRoute(&net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}); err != nil {
+				return err
+			}
+		}
+		if isAssigned(v6Addr.Local) {
+			if err := tif.addRoute(&net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range tif.routeCfg.SplitTunnelCIDRs {
+		if err := tif.addRoute(&tif.routeCfg.SplitTunnelCIDRs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tif *TUNInterface) addRoute(dst *net.IPNet) error {
+	route := &netlink.Route{
+		LinkIndex: tif.netLink.Attrs().Index,
+		Dst:       dst,
+		Table:     tif.routeCfg.Table,
+		Priority:  tif.routeCfg.Metric,
+		Scope:     netlink.SCOPE_LINK,
+	}
+	if err := netlink.RouteAdd(route); err != nil {
+		return fmt.Errorf("failed to add route %v, %w", dst, err)
+	}
+	tif.installedRoutes = append(tif.installedRoutes, route)
+	return nil
+}
+
+// teardownOnDone removes the routes and DNS config this TUNInterface
+// installed once ctx is canceled, i.e. once the PPP session ends.
+func (tif *TUNInterface) teardownOnDone(ctx context.Context) {
+	<-ctx.Done()
+	for _, route := range tif.installedRoutes {
+		if err := netlink.RouteDel(route); err != nil {
+			tif.logger.Warn().Err(err).Msg("failed to remove route")
+		}
+	}
+	if tif.dnsApplier != nil {
+		if err := tif.dnsApplier.Remove(tif.ifName); err != nil {
+			tif.logger.Warn().Err(err).Msg("failed to remove DNS config")
+		}
+	}
+}
+
+// readLoop continuously reads frames off the TUN interface into pooled
+// buffers and hands them to send for batching. Each iteration is still one
+// netInterface.Read syscall per frame: this is the write-side half of batched
+// TUN I/O only. The read side still does not open the TUN with IFF_VNET_HDR
+// or use readv/virtio-net-header-prefixed reads to pull multiple coalesced
+// segments out in one syscall - water's *os.File-backed Interface doesn't
+// expose the fd for that, so doing it would mean dropping water here. What
+// decoupling the read from send does buy is letting send coalesce whatever
+// readLoop has produced into one sendBatchChan delivery, which is what lets
+// the write side (see PPP.writeOutBatch) turn a whole batch into a single
+// transport syscall when the Transport supports it.
+func (tif *TUNInterface) readLoop(ctx context.Context) {
+	defer close(tif.rawChan)
 	for {
-		// Read IPv4 / IPv6 packet to send from TUN interface
-		buf := make([]byte, _defaultMaxFrameSize)
+		buf := tif.bufPool.Get().([]byte)[:_defaultMaxFrameSize]
 		n, err := tif.netInterface.Read(buf)
 		if err != nil {
 			tif.logger.Error().Err(err).Msg("failed to read net interface packet")
 			return
 		}
-		buf = buf[:n]
+		select {
+		case tif.rawChan <- rawFrame{buf: buf, n: n}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// encode validates and PPP-encapsulates a raw frame read from the TUN
+// interface, returning the pooled buffer once it is no longer needed.
+func (tif *TUNInterface) encode(raw rawFrame) []byte {
+	defer tif.bufPool.Put(raw.buf) //nolint:staticcheck // buf is reused via New() on next Get
+	buf := raw.buf[:raw.n]
+
+	// Packet is too small, discard
+	if raw.n < _minimumFrameSize {
+		return nil
+	}
 
-		// Check if context is still valid
+	// Check Version value from IPv4 / IPv6 header, and encapsulate
+	// into PPP accordingly
+	var proto lcp.PPPProtocolNumber
+	switch buf[0] >> 4 {
+	case 4:
+		proto = lcp.ProtoIPv4
+	case 6:
+		proto = lcp.ProtoIPv6
+	default:
+		tif.logger.Info().Msg("unable to send packet with unknown IP version")
+		return nil
+	}
+	pkt, err := lcp.NewPPPPkt(lcp.NewStaticSerializer(buf), proto).Serialize()
+	if err != nil {
+		return nil
+	}
+	return pkt
+}
+
+// send pkt to outside network; it coalesces up to batchSize frames read by
+// readLoop within coalesceWindow into a single batch before handing the
+// whole batch to lcp.PPP in one channel send.
+func (tif *TUNInterface) send(ctx context.Context) {
+	for {
+		var first rawFrame
+		var ok bool
 		select {
 		case <-ctx.Done():
 			tif.logger.Info().Msg("send routine stopped")
 			_ = tif.netInterface.Close()
 			return
-		default:
+		case first, ok = <-tif.rawChan:
+			if !ok {
+				return
+			}
 		}
 
-		// Packet is too small, discard
-		if n < _minimumFrameSize {
-			continue
+		batch := make([][]byte, 0, tif.batchSize)
+		if pkt := tif.encode(first); pkt != nil {
+			batch = append(batch, pkt)
 		}
 
-		// Check Version value from IPv4 / IPv6 header, and encapsulate
-		// into PPP accordingly
-		switch buf[0] >> 4 {
-		case 4:
-			pkt, err := lcp.NewPPPPkt(lcp.NewStaticSerializer(buf[:n]), lcp.ProtoIPv4).Serialize()
-			if err == nil {
-				tif.sendChan <- pkt
-			}
-		case 6:
-			pkt, err := lcp.NewPPPPkt(lcp.NewStaticSerializer(buf[:n]), lcp.ProtoIPv6).Serialize()
-			if err == nil {
-				tif.sendChan <- pkt
+		timer := time.NewTimer(tif.coalesceWindow)
+	coalesce:
+		for len(batch) < tif.batchSize {
+			select {
+			case raw, chOK := <-tif.rawChan:
+				if !chOK {
+					break coalesce
+				}
+				if pkt := tif.encode(raw); pkt != nil {
+					batch = append(batch, pkt)
+				}
+			case <-timer.C:
+				break coalesce
+			case <-ctx.Done():
+				timer.Stop()
+				tif.logger.Info().Msg("send routine stopped")
+				_ = tif.netInterface.Close()
+				return
 			}
-		default:
-			tif.logger.Info().Msg("unable to send packet with unknown IP version")
-			continue
+		}
+		timer.Stop()
+
+		if len(batch) > 0 {
+			tif.sendBatchChan <- batch
 		}
 	}
 }
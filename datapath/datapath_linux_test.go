@@ -0,0 +1,89 @@
+package datapath
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newTestTUNInterface builds a TUNInterface with just the fields send/encode
+// touch, so the coalescing logic can be exercised without a real TUN device.
+func newTestTUNInterface(batchSize int) *TUNInterface {
+	logger := zerolog.Nop()
+	return &TUNInterface{
+		logger:         &logger,
+		rawChan:        make(chan rawFrame, batchSize),
+		sendBatchChan:  make(chan [][]byte, 1),
+		bufPool:        &sync.Pool{New: func() interface{} { return make([]byte, _defaultMaxFrameSize) }},
+		batchSize:      batchSize,
+		coalesceWindow: 50 * time.Millisecond,
+	}
+}
+
+func v4Frame() rawFrame {
+	buf := make([]byte, _minimumFrameSize)
+	buf[0] = 0x45 // IPv4, header length 5
+	return rawFrame{buf: buf, n: len(buf)}
+}
+
+// TestSendCoalescesBatch verifies send() coalesces multiple frames queued on
+// rawChan into a single sendBatchChan delivery, instead of handing them to
+// the Transport one at a time.
+func TestSendCoalescesBatch(t *testing.T) {
+	tif := newTestTUNInterface(3)
+
+	tif.rawChan <- v4Frame()
+	tif.rawChan <- v4Frame()
+	tif.rawChan <- v4Frame()
+	close(tif.rawChan)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		tif.send(ctx)
+		close(done)
+	}()
+
+	select {
+	case batch := <-tif.sendBatchChan:
+		if len(batch) != 3 {
+			t.Fatalf("got batch of %d frames, want 3", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for coalesced batch")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("send did not return after rawChan closed")
+	}
+}
+
+// TestSendFlushesOnCoalesceWindow verifies a partial batch is still flushed
+// once coalesceWindow elapses, rather than waiting indefinitely for batchSize
+// frames that may never arrive.
+func TestSendFlushesOnCoalesceWindow(t *testing.T) {
+	tif := newTestTUNInterface(10)
+
+	tif.rawChan <- v4Frame()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go tif.send(ctx)
+
+	select {
+	case batch := <-tif.sendBatchChan:
+		if len(batch) != 1 {
+			t.Fatalf("got batch of %d frames, want 1", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for coalesce window flush")
+	}
+}
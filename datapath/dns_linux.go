@@ -0,0 +1,195 @@
+package datapath
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gandalfast/zouppp/lcp"
+	"github.com/godbus/dbus/v5"
+	"github.com/vishvananda/netlink"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// DNSApplier applies the DNS/NBNS servers IPCP negotiated for a session onto
+// the host for the TUN interface named ifName, and later reverses it.
+type DNSApplier interface {
+	Apply(ifName string, cfg lcp.DNSConfig) error
+	Remove(ifName string) error
+}
+
+func dnsServers(cfg lcp.DNSConfig) []net.IP {
+	var servers []net.IP
+	for _, ip := range []net.IP{cfg.PrimaryDNS, cfg.SecondaryDNS} {
+		if ip != nil {
+			servers = append(servers, ip)
+		}
+	}
+	return servers
+}
+
+// ResolvConfApplier rewrites /etc/resolv.conf's nameserver lines directly;
+// it is the simplest option, but clobbers any existing resolver config and
+// does not distinguish between interfaces, so the last session to apply
+// wins and the first to tear down restores the pre-session file. A single
+// instance is commonly shared across every session's Apply/Remove calls, so
+// backup/applied are guarded by mu.
+type ResolvConfApplier struct {
+	path string
+
+	mu      sync.Mutex
+	backup  []byte
+	applied bool
+}
+
+// NewResolvConfApplier returns a ResolvConfApplier targeting /etc/resolv.conf.
+func NewResolvConfApplier() *ResolvConfApplier {
+	return &ResolvConfApplier{path: "/etc/resolv.conf"}
+}
+
+// Apply implements DNSApplier
+func (a *ResolvConfApplier) Apply(ifName string, cfg lcp.DNSConfig) error {
+	if cfg.Empty() {
+		return nil
+	}
+	backup, err := os.ReadFile(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %v, %w", a.path, err)
+	}
+
+	var buf bytes.Buffer
+	for _, ip := range dnsServers(cfg) {
+		fmt.Fprintf(&buf, "nameserver %v\n", ip)
+	}
+	if err := os.WriteFile(a.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %v, %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	if !a.applied {
+		a.backup = backup
+		a.applied = true
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// Remove implements DNSApplier
+func (a *ResolvConfApplier) Remove(ifName string) error {
+	a.mu.Lock()
+	if !a.applied {
+		a.mu.Unlock()
+		return nil
+	}
+	a.applied = false
+	backup := a.backup
+	a.mu.Unlock()
+	return os.WriteFile(a.path, backup, 0644)
+}
+
+// SystemdResolvedApplier applies DNS servers via systemd-resolved's D-Bus
+// SetLinkDNS method, scoping them to the TUN interface only.
+type SystemdResolvedApplier struct{}
+
+// NewSystemdResolvedApplier returns a SystemdResolvedApplier.
+func NewSystemdResolvedApplier() *SystemdResolvedApplier {
+	return &SystemdResolvedApplier{}
+}
+
+const (
+	resolvedDest = "org.freedesktop.resolve1"
+	resolvedPath = "/org/freedesktop/resolve1"
+)
+
+type resolvedAddr struct {
+	Family  int32
+	Address []byte
+}
+
+func linkIndexByName(ifName string) (int, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up interface %v, %w", ifName, err)
+	}
+	return link.Attrs().Index, nil
+}
+
+func (a *SystemdResolvedApplier) setLinkDNS(ifName string, addrs []resolvedAddr) error {
+	idx, err := linkIndexByName(ifName)
+	if err != nil {
+		return err
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system D-Bus, %w", err)
+	}
+	defer conn.Close()
+
+	obj := conn.Object(resolvedDest, dbus.ObjectPath(resolvedPath))
+	call := obj.Call("org.freedesktop.resolve1.Manager.SetLinkDNS", 0, idx, addrs)
+	if call.Err != nil {
+		return fmt.Errorf("SetLinkDNS failed, %w", call.Err)
+	}
+	return nil
+}
+
+// Apply implements DNSApplier
+func (a *SystemdResolvedApplier) Apply(ifName string, cfg lcp.DNSConfig) error {
+	if cfg.Empty() {
+		return nil
+	}
+	var addrs []resolvedAddr
+	for _, ip := range dnsServers(cfg) {
+		if v4 := ip.To4(); v4 != nil {
+			addrs = append(addrs, resolvedAddr{Family: 2 /* AF_INET */, Address: v4})
+		} else {
+			addrs = append(addrs, resolvedAddr{Family: 10 /* AF_INET6 */, Address: ip.To16()})
+		}
+	}
+	return a.setLinkDNS(ifName, addrs)
+}
+
+// Remove implements DNSApplier
+func (a *SystemdResolvedApplier) Remove(ifName string) error {
+	return a.setLinkDNS(ifName, nil)
+}
+
+// ResolvconfCmdApplier drives the resolvconf(8) utility, the traditional way
+// to register per-interface nameservers without clobbering other interfaces'.
+type ResolvconfCmdApplier struct{}
+
+// NewResolvconfCmdApplier returns a ResolvconfCmdApplier.
+func NewResolvconfCmdApplier() *ResolvconfCmdApplier {
+	return &ResolvconfCmdApplier{}
+}
+
+func resolvconfRecordName(ifName string) string {
+	return ifName + ".inet"
+}
+
+// Apply implements DNSApplier
+func (a *ResolvconfCmdApplier) Apply(ifName string, cfg lcp.DNSConfig) error {
+	if cfg.Empty() {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, ip := range dnsServers(cfg) {
+		fmt.Fprintf(&buf, "nameserver %v\n", ip)
+	}
+	cmd := exec.Command("resolvconf", "-a", resolvconfRecordName(ifName))
+	cmd.Stdin = &buf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvconf -a failed, %w: %s", err, out)
+	}
+	return nil
+}
+
+// Remove implements DNSApplier
+func (a *ResolvconfCmdApplier) Remove(ifName string) error {
+	cmd := exec.Command("resolvconf", "-d", resolvconfRecordName(ifName))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvconf -d failed, %w: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package datapath
+
+import "net"
+
+// RouteConfig controls which routes NewTUNIf installs via a session's TUN
+// interface, in addition to the interface's own address(es).
+type RouteConfig struct {
+	// DefaultRoute installs a default route (0.0.0.0/0, and/or ::/0 when an
+	// IPv6 address was assigned) via the TUN interface
+	DefaultRoute bool
+	// Table is the routing table ID to install routes into; 0 uses the
+	// kernel's main table
+	Table int
+	// Metric is the route priority/metric; 0 uses the kernel default
+	Metric int
+	// SplitTunnelCIDRs, if non-empty, are installed as additional routes via
+	// the TUN interface; combine with DefaultRoute false to route only
+	// these networks through the session
+	SplitTunnelCIDRs []net.IPNet
+}
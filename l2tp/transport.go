@@ -0,0 +1,143 @@
+// Package l2tp provides an lcp.Transport binding that carries PPP frames
+// over L2TPv2 (RFC 2661), for VPN-style dial-in where the peer is an LNS/LAC
+// reachable over UDP instead of an Ethernet segment.
+package l2tp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	// defaultMTU is the largest PPP frame Transport carries by default
+	defaultMTU = 1500
+	// dataHeaderLen is the length of an L2TP data message header with none
+	// of the optional Length/Ns/Nr/Offset fields present: 2 bytes of
+	// flags/version, then Tunnel ID and Session ID. Send always writes a
+	// header this shape; Receive must not assume a peer's messages are.
+	dataHeaderLen = 6
+	// controlBit is set in the flags/version field of L2TP control messages;
+	// Transport only carries the data channel and ignores these
+	controlBit = 0x8000
+	// lengthBit, sequenceBit and offsetBit mark the presence of the optional
+	// Length, Ns/Nr and Offset Size/Pad fields in a data message header
+	// (RFC 2661 section 3.1); lengthBit shifts Tunnel ID/Session ID, and a
+	// peer with sequencing enabled sets sequenceBit, adding Ns/Nr after
+	// Session ID, so the payload no longer starts at the fixed dataHeaderLen
+	// offset of the shape Send writes.
+	lengthBit   = 0x4000
+	sequenceBit = 0x0800
+	offsetBit   = 0x0200
+	// version2 is the L2TP version number RFC 2661 (section 3.1) requires in
+	// the low nibble of every message's flags/version field
+	version2 = 0x0002
+)
+
+// Transport implements lcp.Transport, carrying PPP frames inside L2TPv2
+// data messages over a UDP socket. It assumes the L2TP tunnel and session
+// have already been established by a separate control-connection
+// implementation, and only handles the data channel: prepending the L2TP
+// header to outbound PPP frames, and stripping it from inbound ones.
+type Transport struct {
+	conn      *net.UDPConn
+	peerAddr  *net.UDPAddr
+	tunnelID  uint16
+	sessionID uint16
+	mtu       int
+}
+
+// NewTransport returns a Transport that carries the L2TP session identified
+// by tunnelID/sessionID over conn, sending to peerAddr.
+func NewTransport(conn *net.UDPConn, peerAddr *net.UDPAddr, tunnelID, sessionID uint16) *Transport {
+	return &Transport{conn: conn, peerAddr: peerAddr, tunnelID: tunnelID, sessionID: sessionID, mtu: defaultMTU}
+}
+
+// Send implements lcp.Transport
+func (t *Transport) Send(b []byte) error {
+	frame := make([]byte, dataHeaderLen+len(b))
+	binary.BigEndian.PutUint16(frame[0:2], version2) // flags/version: data message, no optional fields, version 2
+	binary.BigEndian.PutUint16(frame[2:4], t.tunnelID)
+	binary.BigEndian.PutUint16(frame[4:6], t.sessionID)
+	copy(frame[dataHeaderLen:], b)
+	_, err := t.conn.WriteToUDP(frame, t.peerAddr)
+	return err
+}
+
+// Receive implements lcp.Transport
+func (t *Transport) Receive() ([]byte, error) {
+	buf := make([]byte, t.mtu+dataHeaderLen+optionalHeaderMaxLen)
+	for {
+		n, _, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recv from L2TP socket, %w", err)
+		}
+		msg := buf[:n]
+		if len(msg) < 2 || binary.BigEndian.Uint16(msg[0:2])&controlBit != 0 {
+			continue // control message, not a PPP data frame
+		}
+		payloadOff, tunnelID, sessionID, ok := dataMessageHeader(msg)
+		if !ok {
+			continue
+		}
+		if tunnelID != t.tunnelID || sessionID != t.sessionID {
+			continue
+		}
+		out := make([]byte, n-payloadOff)
+		copy(out, msg[payloadOff:])
+		return out, nil
+	}
+}
+
+// optionalHeaderMaxLen is the largest a data message header can grow past
+// dataHeaderLen: 2 bytes of Length, 4 bytes of Ns/Nr, and 2 bytes of Offset
+// Size (the offset pad it introduces is read separately, not pre-sized here).
+const optionalHeaderMaxLen = 8
+
+// dataMessageHeader parses the flags field of an L2TP data message and
+// returns the offset its PPP payload starts at along with its Tunnel ID and
+// Session ID, accounting for whichever of the optional Length/Ns/Nr/Offset
+// fields (RFC 2661 section 3.1) the peer's flags bits say are present. ok is
+// false if msg is too short for the header its own flags describe.
+func dataMessageHeader(msg []byte) (payloadOff int, tunnelID, sessionID uint16, ok bool) {
+	flags := binary.BigEndian.Uint16(msg[0:2])
+	pos := 2
+	if flags&lengthBit != 0 {
+		pos += 2
+	}
+	if pos+4 > len(msg) {
+		return 0, 0, 0, false
+	}
+	tunnelID = binary.BigEndian.Uint16(msg[pos : pos+2])
+	sessionID = binary.BigEndian.Uint16(msg[pos+2 : pos+4])
+	pos += 4
+	if flags&sequenceBit != 0 {
+		pos += 4
+	}
+	if flags&offsetBit != 0 {
+		if pos+2 > len(msg) {
+			return 0, 0, 0, false
+		}
+		offsetSize := int(binary.BigEndian.Uint16(msg[pos : pos+2]))
+		pos += 2 + offsetSize
+	}
+	if pos > len(msg) {
+		return 0, 0, 0, false
+	}
+	return pos, tunnelID, sessionID, true
+}
+
+// MTU implements lcp.Transport
+func (t *Transport) MTU() int {
+	return t.mtu
+}
+
+// Close implements lcp.Transport
+func (t *Transport) Close() error {
+	return t.conn.Close()
+}
+
+// PeerName implements lcp.Transport
+func (t *Transport) PeerName() string {
+	return fmt.Sprintf("l2tp:%v/%d/%d", t.peerAddr, t.tunnelID, t.sessionID)
+}
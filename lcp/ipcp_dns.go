@@ -0,0 +1,67 @@
+package lcp
+
+import "net"
+
+// IPCP DNS/NBNS option types, see RFC 1877
+const (
+	// IPCPOptionPrimaryDNS is the Primary DNS Server Address option (MS-DNS1)
+	IPCPOptionPrimaryDNS = 129
+	// IPCPOptionPrimaryNBNS is the Primary NBNS Server Address option (MS-WINS1)
+	IPCPOptionPrimaryNBNS = 130
+	// IPCPOptionSecondaryDNS is the Secondary DNS Server Address option (MS-DNS2)
+	IPCPOptionSecondaryDNS = 131
+	// IPCPOptionSecondaryNBNS is the Secondary NBNS Server Address option (MS-WINS2)
+	IPCPOptionSecondaryNBNS = 132
+)
+
+// DNSConfig holds the DNS/NBNS server addresses negotiated via IPCP
+// (RFC 1877 options 129/131 for DNS, 130/132 for NBNS); a nil field means
+// that server wasn't negotiated. ParseDNSOptions populates this from the
+// raw IPCP options of a peer's Configure-Request/Ack; the result is passed
+// to datapath.NewTUNIf via datapath.WithDNS.
+type DNSConfig struct {
+	PrimaryDNS    net.IP
+	SecondaryDNS  net.IP
+	PrimaryNBNS   net.IP
+	SecondaryNBNS net.IP
+}
+
+// Empty reports whether no DNS/NBNS server was negotiated.
+func (d DNSConfig) Empty() bool {
+	return d.PrimaryDNS == nil && d.SecondaryDNS == nil && d.PrimaryNBNS == nil && d.SecondaryNBNS == nil
+}
+
+// ParseDNSOptions walks the TLV-encoded IPCP options carried in a
+// Configure-Request or Configure-Ack (the option bytes following the 4-byte
+// Code/Identifier/Length header - this package does not run the IPCP state
+// machine itself, see session.Supervisor's IPCPComplete event, so a caller
+// driving that negotiation hands this the options it already split out of
+// the packet) and extracts the options 129-132 addresses defined by RFC
+// 1877. Each option is 1 byte type + 1 byte length (6, covering itself) + a
+// 4-byte IPv4 address; unrecognized option types are skipped using their
+// own length so parsing stays in sync, and a truncated or malformed option
+// stops parsing and returns whatever was extracted so far.
+func ParseDNSOptions(options []byte) DNSConfig {
+	var cfg DNSConfig
+	for len(options) >= 2 {
+		optType, optLen := options[0], int(options[1])
+		if optLen < 2 || optLen > len(options) {
+			break
+		}
+		value := options[2:optLen]
+		if len(value) == net.IPv4len {
+			switch optType {
+			case IPCPOptionPrimaryDNS:
+				cfg.PrimaryDNS = net.IPv4(value[0], value[1], value[2], value[3])
+			case IPCPOptionPrimaryNBNS:
+				cfg.PrimaryNBNS = net.IPv4(value[0], value[1], value[2], value[3])
+			case IPCPOptionSecondaryDNS:
+				cfg.SecondaryDNS = net.IPv4(value[0], value[1], value[2], value[3])
+			case IPCPOptionSecondaryNBNS:
+				cfg.SecondaryNBNS = net.IPv4(value[0], value[1], value[2], value[3])
+			}
+		}
+		options = options[optLen:]
+	}
+	return cfg
+}
@@ -0,0 +1,42 @@
+package lcp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseDNSOptions(t *testing.T) {
+	options := []byte{
+		IPCPOptionPrimaryDNS, 6, 8, 8, 8, 8,
+		IPCPOptionSecondaryNBNS, 6, 10, 0, 0, 1,
+	}
+
+	cfg := ParseDNSOptions(options)
+
+	if !cfg.PrimaryDNS.Equal(net.IPv4(8, 8, 8, 8)) {
+		t.Fatalf("PrimaryDNS = %v, want 8.8.8.8", cfg.PrimaryDNS)
+	}
+	if !cfg.SecondaryNBNS.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("SecondaryNBNS = %v, want 10.0.0.1", cfg.SecondaryNBNS)
+	}
+	if cfg.PrimaryNBNS != nil || cfg.SecondaryDNS != nil {
+		t.Fatalf("unexpected options populated: %+v", cfg)
+	}
+}
+
+func TestParseDNSOptionsEmpty(t *testing.T) {
+	if cfg := ParseDNSOptions(nil); !cfg.Empty() {
+		t.Fatalf("ParseDNSOptions(nil) = %+v, want Empty", cfg)
+	}
+}
+
+func TestParseDNSOptionsTruncated(t *testing.T) {
+	// A length byte claiming more data than is present must stop parsing
+	// instead of panicking on an out-of-range slice.
+	options := []byte{IPCPOptionPrimaryDNS, 6, 8, 8}
+
+	cfg := ParseDNSOptions(options)
+	if !cfg.Empty() {
+		t.Fatalf("ParseDNSOptions(truncated) = %+v, want Empty", cfg)
+	}
+}
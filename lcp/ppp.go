@@ -3,12 +3,10 @@ package lcp
 import (
 	"context"
 	"encoding/binary"
-	"errors"
 	"fmt"
-	"github.com/gandalfast/zouppp/etherconn"
 	"github.com/rs/zerolog"
-	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -51,31 +49,123 @@ func NewPPPPkt(data Serializer, proto PPPProtocolNumber) *PPPPacket {
 type PPP struct {
 	relayChanList     map[PPPProtocolNumber]chan []byte
 	sendChan          chan []byte
+	sendBatchChan     chan [][]byte
 	relayChanListLock *sync.RWMutex
-	conn              net.PacketConn
+	transport         Transport
 	logger            *zerolog.Logger
 	reqID             uint8 //used by send project-reject
+	batchSize         int
+	coalesceWindow    time.Duration
+	txBytes           uint64
+	rxBytes           uint64
+	done              chan struct{}
+	doneOnce          sync.Once
+	doneErrMu         sync.Mutex
+	doneErr           error
 }
 
-// NewPPP creates a new PPP protocol instance, using conn as underlying transport, l as logger;
-func NewPPP(ctx context.Context, conn net.PacketConn, l *zerolog.Logger) *PPP {
+// Option customizes a PPP instance created via NewPPP
+type Option func(*PPP)
+
+// WithBatchSize sets the max number of frames drained per iteration of send
+// for callers using the batched channel returned by RegisterBatch; n must be > 0.
+func WithBatchSize(n int) Option {
+	return func(ppp *PPP) {
+		if n > 0 {
+			ppp.batchSize = n
+		}
+	}
+}
+
+// WithCoalesceWindow sets how long send waits for a batch of frames from the
+// batched channel to fill up to BatchSize before issuing them.
+func WithCoalesceWindow(d time.Duration) Option {
+	return func(ppp *PPP) {
+		if d > 0 {
+			ppp.coalesceWindow = d
+		}
+	}
+}
+
+const (
+	relayChanDepth = 128
+	sendCHanDepth  = 128
+	// MaxPPPMsgSize specifies max length of a received PPP pkt
+	MaxPPPMsgSize = 1500
+	// defaultBatchSize is the default max number of frames drained per
+	// iteration of send when using the batched channel from RegisterBatch
+	defaultBatchSize = 64
+	// defaultCoalesceWindow is how long send waits for more frames to
+	// coalesce into one batch before giving up and issuing what it has
+	defaultCoalesceWindow = 50 * time.Microsecond
+)
+
+// NewPPP creates a new PPP protocol instance, using transport as the
+// underlying Transport, l as logger; options can override batching defaults
+// used by the channel returned from RegisterBatch. Canceling ctx closes
+// transport, which unblocks its Receive and stops PPP's goroutines.
+func NewPPP(ctx context.Context, transport Transport, l *zerolog.Logger, options ...Option) *PPP {
 	r := new(PPP)
 	r.relayChanList = make(map[PPPProtocolNumber]chan []byte)
 	r.relayChanListLock = new(sync.RWMutex)
-	r.conn = conn
+	r.transport = transport
 	r.sendChan = make(chan []byte, sendCHanDepth)
+	r.sendBatchChan = make(chan [][]byte, sendCHanDepth)
 	r.logger = l
+	r.batchSize = defaultBatchSize
+	r.coalesceWindow = defaultCoalesceWindow
+	r.done = make(chan struct{})
+	for _, option := range options {
+		option(r)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = r.transport.Close()
+	}()
 	go r.recv(ctx)
 	go r.send(ctx)
 	return r
 }
 
-const (
-	relayChanDepth = 128
-	sendCHanDepth  = 128
-	// MaxPPPMsgSize specifies max length of a received PPP pkt
-	MaxPPPMsgSize = 1500
-)
+// Done returns a channel that is closed once ppp's receive loop exits, for
+// any reason: ctx was canceled (see NewPPP), or the Transport returned an
+// error on its own (peer sent a PADT, underlying socket died, ...). Callers
+// that need to notice an unsupervised session death - e.g.
+// session.Supervisor - select on Done instead of only acting on their own
+// explicit teardown calls. Err reports which of those two happened.
+func (ppp *PPP) Done() <-chan struct{} {
+	return ppp.done
+}
+
+// Err returns the error that caused Done to close, or nil if recv exited
+// because ctx was canceled rather than because the Transport failed on its
+// own. Err is only meaningful after Done is closed.
+func (ppp *PPP) Err() error {
+	ppp.doneErrMu.Lock()
+	defer ppp.doneErrMu.Unlock()
+	return ppp.doneErr
+}
+
+func (ppp *PPP) markDone(err error) {
+	ppp.doneOnce.Do(func() {
+		ppp.doneErrMu.Lock()
+		ppp.doneErr = err
+		ppp.doneErrMu.Unlock()
+		close(ppp.done)
+	})
+}
+
+// TXBytes returns the total number of PPP-framed bytes successfully handed
+// to the Transport via Send/SendBatch since this PPP was created.
+func (ppp *PPP) TXBytes() uint64 {
+	return atomic.LoadUint64(&ppp.txBytes)
+}
+
+// RXBytes returns the total number of PPP-framed bytes successfully read
+// back from the Transport since this PPP was created.
+func (ppp *PPP) RXBytes() uint64 {
+	return atomic.LoadUint64(&ppp.rxBytes)
+}
 
 // Register a new protocol to run over ppp;
 // return two byte slice channels, send could use to send pkt over ppp, recv is used to recv pkt from ppp
@@ -89,6 +179,19 @@ func (ppp *PPP) Register(p PPPProtocolNumber) (send, recv chan []byte) {
 	return
 }
 
+// RegisterBatch is like Register, except sendBatch lets the caller hand over
+// a slice of frames in one channel send instead of one frame at a time; send
+// merges the slices queued on sendBatch until it holds at least BatchSize
+// frames or CoalesceWindow elapses (a merged batch can exceed BatchSize by
+// up to one slice), and issues them together, which is useful for
+// callers like datapath.TUNInterface that coalesce bursts of packets read
+// from a TUN device.
+func (ppp *PPP) RegisterBatch(p PPPProtocolNumber) (sendBatch chan [][]byte, recv chan []byte) {
+	_, recv = ppp.Register(p)
+	sendBatch = ppp.sendBatchChan
+	return
+}
+
 // Un-register the protocol;
 func (ppp *PPP) UnRegister(p PPPProtocolNumber) {
 	ppp.relayChanListLock.Lock()
@@ -109,33 +212,76 @@ func (ppp *PPP) send(ctx context.Context) {
 			ppp.logger.Info().Msg("ppp send routined stopped")
 			return
 		case b := <-ppp.sendChan:
-			if _, err := ppp.conn.WriteTo(b, nil); err != nil {
-				ppp.logger.Warn().Err(err).Msg("failed to send pkt")
+			ppp.writeOut(b)
+		case batch := <-ppp.sendBatchChan:
+			// keep merging slices handed over by RegisterBatch until the
+			// batch holds BatchSize frames or CoalesceWindow elapses, so a
+			// burst is issued back-to-back instead of round-tripping
+			// through the scheduler per frame
+			timer := time.NewTimer(ppp.coalesceWindow)
+		drain:
+			for len(batch) < ppp.batchSize {
+				select {
+				case b := <-ppp.sendBatchChan:
+					batch = append(batch, b...)
+				case <-timer.C:
+					break drain
+				case <-ctx.Done():
+					break drain
+				}
 			}
+			timer.Stop()
+			ppp.writeOutBatch(batch)
 		}
 	}
 }
 
-func (ppp *PPP) recv(ctx context.Context) {
-	for {
-		buf := make([]byte, MaxPPPMsgSize)
-		ppp.conn.SetReadDeadline(time.Now().Add(readTimeout))
-		n, _, err := ppp.conn.ReadFrom(buf)
+func (ppp *PPP) writeOut(b []byte) {
+	if err := ppp.transport.Send(b); err != nil {
+		ppp.logger.Warn().Err(err).Msg("failed to send pkt")
+		return
+	}
+	atomic.AddUint64(&ppp.txBytes, uint64(len(b)))
+}
 
-		if err != nil && !errors.Is(err, etherconn.ErrTimeOut) {
-			ppp.logger.Error().Err(err).Msg("failed to recv")
+// writeOutBatch issues batch to the transport in as few syscalls as the
+// transport allows: if it implements BatchTransport, the whole batch goes
+// out through a single SendBatch call (e.g. one sendmmsg(2)); otherwise it
+// falls back to one Send per frame, same as the non-batched path.
+func (ppp *PPP) writeOutBatch(batch [][]byte) {
+	if bt, ok := ppp.transport.(BatchTransport); ok {
+		if err := bt.SendBatch(batch); err != nil {
+			ppp.logger.Warn().Err(err).Msg("failed to send batch")
 			return
-		} else if err != nil {
+		}
+		var n uint64
+		for _, b := range batch {
+			n += uint64(len(b))
+		}
+		atomic.AddUint64(&ppp.txBytes, n)
+		return
+	}
+	for _, b := range batch {
+		ppp.writeOut(b)
+	}
+}
+
+func (ppp *PPP) recv(ctx context.Context) {
+	for {
+		buf, err := ppp.transport.Receive()
+		if err != nil {
 			select {
 			case <-ctx.Done():
 				ppp.logger.Info().Msg("ppp recv routined stopped")
-				return
+				ppp.markDone(nil)
 			default:
+				ppp.logger.Error().Err(err).Msg("failed to recv")
+				ppp.markDone(err)
 			}
-			continue
+			return
 		}
-
-		go ppp.relay(buf[:n])
+		atomic.AddUint64(&ppp.rxBytes, uint64(len(buf)))
+		go ppp.relay(buf)
 	}
 }
 
@@ -0,0 +1,105 @@
+package lcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Transport abstracts the substrate PPP frames travel over, the way
+// WireGuard's Bind abstracts its protocol over a UDP socket. PPP itself only
+// needs to send and receive whole frames; how those frames actually reach
+// the peer (PPPoE over etherconn, a raw AF_PACKET socket, L2TP, an in-memory
+// pipe for tests, ...) is left entirely to the implementation.
+type Transport interface {
+	// Send writes one PPP frame to the peer
+	Send(b []byte) error
+	// Receive blocks until one PPP frame has been read from the peer, or
+	// returns an error; Close unblocks a pending Receive
+	Receive() ([]byte, error)
+	// MTU is the largest PPP frame this Transport can carry
+	MTU() int
+	// Close releases the Transport's resources and unblocks any pending Receive
+	Close() error
+	// PeerName identifies the remote peer, for logging
+	PeerName() string
+}
+
+// BatchTransport is an optional extension to Transport for implementations
+// that can write several frames to the peer in one syscall, e.g. via
+// sendmmsg(2); PPP's send loop uses it instead of calling Send once per
+// frame for batches handed over via RegisterBatch, whenever the configured
+// Transport implements it. Frames sent one at a time still go through Send.
+type BatchTransport interface {
+	Transport
+	// SendBatch writes every frame in batch to the peer; implementations
+	// should issue as few syscalls as the underlying substrate allows
+	SendBatch(batch [][]byte) error
+}
+
+// connTransportLenPrefix is the size, in bytes, of the big-endian frame
+// length ConnTransport writes ahead of every frame
+const connTransportLenPrefix = 2
+
+// ConnTransport adapts a net.Conn, e.g. one half of net.Pipe(), into a
+// Transport; it lets tests drive the PPP state machine without root
+// privilege or a real veth pair. Unlike a datagram socket, a net.Conn gives
+// no guarantee that one Read returns exactly one Write's worth of bytes, so
+// ConnTransport prefixes every frame with its length and reassembles on the
+// receive side; this makes it safe to use over a real stream conn (e.g.
+// net.Pipe or TCP), not just ones that happen to preserve write boundaries.
+type ConnTransport struct {
+	conn net.Conn
+	mtu  int
+	name string
+}
+
+// NewConnTransport returns a Transport backed by conn; mtu bounds the
+// largest frame Send will carry (Receive accepts any length its 2-byte
+// prefix states), and name is returned by PeerName.
+func NewConnTransport(conn net.Conn, mtu int, name string) *ConnTransport {
+	return &ConnTransport{conn: conn, mtu: mtu, name: name}
+}
+
+// Send implements Transport
+func (c *ConnTransport) Send(b []byte) error {
+	if len(b) > c.mtu {
+		return fmt.Errorf("frame of %d bytes exceeds MTU %d", len(b), c.mtu)
+	}
+	header := make([]byte, connTransportLenPrefix)
+	binary.BigEndian.PutUint16(header, uint16(len(b)))
+	if _, err := c.conn.Write(append(header, b...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Receive implements Transport
+func (c *ConnTransport) Receive() ([]byte, error) {
+	header := make([]byte, connTransportLenPrefix)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(header)
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// MTU implements Transport
+func (c *ConnTransport) MTU() int {
+	return c.mtu
+}
+
+// Close implements Transport
+func (c *ConnTransport) Close() error {
+	return c.conn.Close()
+}
+
+// PeerName implements Transport
+func (c *ConnTransport) PeerName() string {
+	return c.name
+}
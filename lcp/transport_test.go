@@ -0,0 +1,64 @@
+package lcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTransportSendReceive(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	ta := NewConnTransport(a, 1500, "a")
+	tb := NewConnTransport(b, 1500, "b")
+
+	want := []byte{0xc0, 0x21, 1, 2, 3}
+	errCh := make(chan error, 1)
+	go func() { errCh <- ta.Send(want) }()
+
+	got, err := tb.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("Receive returned %v, want %v", got, want)
+	}
+}
+
+// TestConnTransportSplitWrites guards against regressing to one Read() per
+// frame: net.Conn gives no guarantee a frame arrives in a single Read, so
+// ConnTransport must reassemble by its own length prefix rather than
+// trusting read boundaries.
+func TestConnTransportSplitWrites(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	tb := NewConnTransport(b, 1500, "b")
+
+	frame := []byte{0xc0, 0x21, 1, 2, 3, 4, 5}
+	header := []byte{0, byte(len(frame))}
+
+	go func() {
+		// Write the length prefix and payload as two separate Writes, and
+		// stagger them, to simulate a conn that doesn't preserve the
+		// sender's write boundaries.
+		a.Write(header[:1])
+		time.Sleep(10 * time.Millisecond)
+		a.Write(header[1:])
+		a.Write(frame)
+	}()
+
+	got, err := tb.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(got) != string(frame) {
+		t.Fatalf("Receive returned %v, want %v", got, frame)
+	}
+}
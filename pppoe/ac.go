@@ -0,0 +1,442 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gandalfast/zouppp/etherconn"
+	"github.com/gandalfast/zouppp/lcp"
+	"github.com/rs/zerolog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionAllocator assigns and reclaims PPPoE session IDs for an
+// AccessConcentrator; implementations must be safe for concurrent use.
+type SessionAllocator interface {
+	// Allocate returns a new, currently unused PPPoE session ID; session ID
+	// 0 is reserved by RFC2516 and must never be returned.
+	Allocate() (uint16, error)
+	// Release returns a session ID to the pool once its session has ended.
+	Release(id uint16)
+}
+
+// defaultSessionAllocator hands out session IDs sequentially, skipping the
+// reserved 0 value and any ID still in use.
+type defaultSessionAllocator struct {
+	mu   sync.Mutex
+	next uint16
+	used map[uint16]bool
+}
+
+func newDefaultSessionAllocator() *defaultSessionAllocator {
+	return &defaultSessionAllocator{
+		next: 1,
+		used: make(map[uint16]bool),
+	}
+}
+
+func (a *defaultSessionAllocator) Allocate() (uint16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := 0; i < 0xffff; i++ {
+		id := a.next
+		a.next++
+		if a.next == 0 {
+			a.next = 1
+		}
+		if id != 0 && !a.used[id] {
+			a.used[id] = true
+			return id, nil
+		}
+	}
+	return 0, errors.New("no session ID available")
+}
+
+func (a *defaultSessionAllocator) Release(id uint16) {
+	a.mu.Lock()
+	delete(a.used, id)
+	a.mu.Unlock()
+}
+
+// ServiceNameMatcher decides whether an AccessConcentrator should respond to
+// a PADI/PADR carrying the given requested Service-Name tag value.
+type ServiceNameMatcher interface {
+	Match(requested string) bool
+}
+
+// ServiceNameMatcherFunc adapts a plain function to a ServiceNameMatcher.
+type ServiceNameMatcherFunc func(string) bool
+
+// Match implements ServiceNameMatcher
+func (f ServiceNameMatcherFunc) Match(requested string) bool {
+	return f(requested)
+}
+
+// AnyServiceName is a ServiceNameMatcher that accepts any requested service
+// name, including an empty one; it is the default used by NewAccessConcentrator.
+var AnyServiceName ServiceNameMatcher = ServiceNameMatcherFunc(func(string) bool { return true })
+
+// acSession is one session the AccessConcentrator has accepted: the PPPoE
+// transport plus the lcp.PPP multiplexer running over it, so a caller can
+// register protocols (e.g. LCP/IPCP) on ppp the same way session.Supervisor
+// does for the client side - see PPP.
+type acSession struct {
+	pppoe  *PPPoE
+	ppp    *lcp.PPP
+	cancel context.CancelFunc
+}
+
+// AccessConcentrator implements the PPPoE server (AC) role defined in
+// RFC2516: it listens for PADI broadcasts, replies with PADO, validates the
+// following PADR and replies with PADS, allocating a session ID for each
+// accepted subscriber.
+//
+// AccessConcentrator only runs the PPPoE discovery exchange; like
+// session.Supervisor on the client side, it does not itself drive LCP/IPCP
+// negotiation or authentication. It wires an lcp.PPP per accepted session
+// (see PPP) so a caller can register the protocols it needs over it.
+type AccessConcentrator struct {
+	acName        string
+	serviceName   string
+	tags          []Tag
+	conn          *etherconn.EtherConn
+	allocator     SessionAllocator
+	svcMatcher    ServiceNameMatcher
+	logger        *zerolog.Logger
+	sessionsMu    *sync.RWMutex
+	sessions      map[uint16]*acSession
+	sessionsByMAC map[string]uint16
+}
+
+// ACModifier customizes configuration of an AccessConcentrator
+type ACModifier func(ac *AccessConcentrator)
+
+// WithACServiceName sets the AC's own Service-Name, advertised in PADO/PADS
+func WithACServiceName(s string) ACModifier {
+	return func(ac *AccessConcentrator) {
+		ac.serviceName = s
+	}
+}
+
+// WithACTags adds extra tags to include in every PADO, e.g. AC-Cookie
+func WithACTags(t []Tag) ACModifier {
+	return func(ac *AccessConcentrator) {
+		if t != nil {
+			ac.tags = t
+		}
+	}
+}
+
+// WithACSessionAllocator overrides the default sequential SessionAllocator
+func WithACSessionAllocator(a SessionAllocator) ACModifier {
+	return func(ac *AccessConcentrator) {
+		if a != nil {
+			ac.allocator = a
+		}
+	}
+}
+
+// WithACServiceNameMatcher overrides the default AnyServiceName matcher, to
+// reject PADI/PADR requesting a Service-Name the AC doesn't offer
+func WithACServiceNameMatcher(m ServiceNameMatcher) ACModifier {
+	return func(ac *AccessConcentrator) {
+		if m != nil {
+			ac.svcMatcher = m
+		}
+	}
+}
+
+// NewAccessConcentrator returns a new AccessConcentrator identifying itself
+// as acName, using conn as the underlying transport and logger for logging;
+// call Serve to start answering PADI broadcasts.
+func NewAccessConcentrator(acName string, conn *etherconn.EtherConn, logger *zerolog.Logger, options ...ACModifier) *AccessConcentrator {
+	ac := new(AccessConcentrator)
+	ac.acName = acName
+	ac.conn = conn
+	ac.logger = logger
+	ac.allocator = newDefaultSessionAllocator()
+	ac.svcMatcher = AnyServiceName
+	ac.sessionsMu = new(sync.RWMutex)
+	ac.sessions = make(map[uint16]*acSession)
+	ac.sessionsByMAC = make(map[string]uint16)
+	for _, option := range options {
+		option(ac)
+	}
+	return ac
+}
+
+// Session returns the open PPPoE session bound to id, if any.
+func (ac *AccessConcentrator) Session(id uint16) (*PPPoE, bool) {
+	ac.sessionsMu.RLock()
+	defer ac.sessionsMu.RUnlock()
+	s, ok := ac.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	return s.pppoe, true
+}
+
+// PPP returns the lcp.PPP multiplexer running over the session bound to id,
+// if any; callers drive LCP/IPCP negotiation (and whatever else runs over
+// PPP) against it, the same way they would for a client session's PPP
+// instance.
+func (ac *AccessConcentrator) PPP(id uint16) (*lcp.PPP, bool) {
+	ac.sessionsMu.RLock()
+	defer ac.sessionsMu.RUnlock()
+	s, ok := ac.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	return s.ppp, true
+}
+
+// Serve reads PPPoE discovery packets from conn until ctx is canceled,
+// answering PADI with PADO, PADR with PADS, and tearing down sessions on PADT.
+func (ac *AccessConcentrator) Serve(ctx context.Context) error {
+	ac.logger.Info().Str("ACName", ac.acName).Msg("AC serving PPPoE discovery requests")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		ac.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		rcvpktbuf, l2ep, err := ac.conn.ReadPkt()
+		if err != nil {
+			if errors.Is(err, etherconn.ErrTimeOut) {
+				continue
+			}
+			return fmt.Errorf("AC failed to recv, %w", err)
+		}
+		pkt := new(Packet)
+		if err := pkt.Parse(rcvpktbuf); err != nil {
+			continue
+		}
+		switch pkt.Code {
+		case CodePADI:
+			go ac.handlePADI(pkt, l2ep.HwAddr)
+		case CodePADR:
+			go ac.handlePADR(ctx, pkt, l2ep.HwAddr)
+		case CodePADT:
+			ac.handlePADT(pkt)
+		case CodeSession:
+			ac.dispatchSessionFrame(ctx, pkt)
+		}
+	}
+}
+
+// dispatchSessionFrame delivers a session-data frame to the session it
+// belongs to, looked up by the PPPoE session ID Serve already parsed out of
+// pkt - an O(1) map lookup instead of every session's own ReadFrom
+// re-scanning each frame for its session ID and source MAC.
+func (ac *AccessConcentrator) dispatchSessionFrame(ctx context.Context, pkt *Packet) {
+	ac.sessionsMu.RLock()
+	sess, ok := ac.sessions[pkt.SessionID]
+	ac.sessionsMu.RUnlock()
+	if !ok {
+		return
+	}
+	sess.pppoe.dispatch(ctx, pkt.Payload)
+}
+
+func serviceNameOf(pkt *Packet) string {
+	tags := pkt.GetTag(TagTypeServiceName)
+	if len(tags) == 0 {
+		return ""
+	}
+	if ts, ok := tags[0].(*TagString); ok {
+		return string(ts.Value)
+	}
+	if tb, ok := tags[0].(*TagByteSlice); ok {
+		return string(tb.Value)
+	}
+	return ""
+}
+
+// tagValue returns t's raw byte value, if t is one of the tag types this
+// package builds/parses.
+func tagValue(t Tag) ([]byte, bool) {
+	if ts, ok := t.(*TagString); ok {
+		return ts.Value, true
+	}
+	if tb, ok := t.(*TagByteSlice); ok {
+		return tb.Value, true
+	}
+	return nil, false
+}
+
+// tagValueOfType returns the raw byte value of the first tag of type
+// tagType among tags, if any.
+func tagValueOfType(tags []Tag, tagType TagType) ([]byte, bool) {
+	for _, t := range tags {
+		if t.Type() != uint16(tagType) {
+			continue
+		}
+		return tagValue(t)
+	}
+	return nil, false
+}
+
+func (ac *AccessConcentrator) buildPADOWithPADI(padi *Packet) *Packet {
+	pado := new(Packet)
+	pado.Code = CodePADO
+	pado.SessionID = 0
+	pado.Tags = []Tag{
+		&TagString{
+			TagByteSlice: &TagByteSlice{
+				TagType: TagTypeACName,
+				Value:   []byte(ac.acName),
+			},
+		},
+		&TagString{
+			TagByteSlice: &TagByteSlice{
+				TagType: TagTypeServiceName,
+				Value:   []byte(ac.serviceName),
+			},
+		},
+	}
+	pado.Tags = append(pado.Tags, ac.tags...)
+	pado.Tags = append(pado.Tags, copyTagsOfType(padi, TagTypeHostUniq, TagTypeRelaySessionID)...)
+	return pado
+}
+
+func (ac *AccessConcentrator) buildPADSWithPADR(padr *Packet, sessionID uint16) *Packet {
+	pads := new(Packet)
+	pads.Code = CodePADS
+	pads.SessionID = sessionID
+	pads.Tags = []Tag{
+		&TagString{
+			TagByteSlice: &TagByteSlice{
+				TagType: TagTypeServiceName,
+				Value:   []byte(ac.serviceName),
+			},
+		},
+	}
+	pads.Tags = append(pads.Tags, copyTagsOfType(padr, TagTypeHostUniq, TagTypeRelaySessionID)...)
+	return pads
+}
+
+func (ac *AccessConcentrator) handlePADI(padi *Packet, peerMAC net.HardwareAddr) {
+	svc := serviceNameOf(padi)
+	if !ac.svcMatcher.Match(svc) {
+		ac.logger.Debug().Str("ServiceName", svc).Msg("ignoring PADI, service name not matched")
+		return
+	}
+	pado := ac.buildPADOWithPADI(padi)
+	pktbytes, err := pado.Serialize()
+	if err != nil {
+		ac.logger.Warn().Err(err).Msg("failed to serialize PADO")
+		return
+	}
+	if _, err := ac.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, peerMAC); err != nil {
+		ac.logger.Warn().Err(err).Msg("failed to send PADO")
+	}
+}
+
+func (ac *AccessConcentrator) handlePADR(ctx context.Context, padr *Packet, peerMAC net.HardwareAddr) {
+	svc := serviceNameOf(padr)
+	if !ac.svcMatcher.Match(svc) {
+		ac.logger.Debug().Str("ServiceName", svc).Msg("ignoring PADR, service name not matched")
+		return
+	}
+
+	// If we handed out an AC-Cookie in PADO (via WithACTags), the PADR must
+	// echo it back unchanged; this is the round-trip check RFC2516 uses the
+	// AC-Cookie for, rejecting a PADR that was never built from one of our
+	// own PADOs (forged, replayed against a stale/garbage cookie, ...).
+	if wantCookie, ok := tagValueOfType(ac.tags, TagTypeACCookie); ok {
+		gotCookie, ok := tagValueOfType(padr.Tags, TagTypeACCookie)
+		if !ok || !bytes.Equal(gotCookie, wantCookie) {
+			ac.logger.Warn().Str("Peer", peerMAC.String()).Msg("ignoring PADR with missing or mismatched AC-Cookie")
+			return
+		}
+	}
+
+	macKey := peerMAC.String()
+
+	// The whole check-existing/allocate/insert sequence runs under one
+	// Lock, not a check under RLock followed by an unlocked allocate+insert:
+	// two PADRs arriving concurrently from the same MAC (a realistic
+	// retransmit race) would otherwise both pass the "do we already have a
+	// session for this peer" check before either inserted into
+	// sessionsByMAC, each allocating (and leaking) its own session.
+	ac.sessionsMu.Lock()
+	defer ac.sessionsMu.Unlock()
+
+	if existingID, retransmit := ac.sessionsByMAC[macKey]; retransmit {
+		// The peer already has a session open with us, most likely because
+		// our PADS was lost and it retransmitted the PADR: resend PADS for
+		// the existing session instead of allocating (and leaking) another one.
+		ac.sendPADS(padr, peerMAC, existingID)
+		return
+	}
+
+	id, err := ac.allocator.Allocate()
+	if err != nil {
+		ac.logger.Warn().Err(err).Msg("failed to allocate session ID")
+		return
+	}
+
+	if err := ac.sendPADS(padr, peerMAC, id); err != nil {
+		ac.allocator.Release(id)
+		return
+	}
+
+	session := ac.newServerSession(ctx, peerMAC, id)
+	ac.sessions[id] = session
+	ac.sessionsByMAC[macKey] = id
+	ac.logger.Info().Uint16("SessionID", id).Str("Peer", peerMAC.String()).Msg("PPPoE session opened")
+}
+
+func (ac *AccessConcentrator) sendPADS(padr *Packet, peerMAC net.HardwareAddr, sessionID uint16) error {
+	pads := ac.buildPADSWithPADR(padr, sessionID)
+	pktbytes, err := pads.Serialize()
+	if err != nil {
+		ac.logger.Warn().Err(err).Msg("failed to serialize PADS")
+		return err
+	}
+	if _, err := ac.conn.WritePktTo(pktbytes, EtherTypePPPoEDiscovery, peerMAC); err != nil {
+		ac.logger.Warn().Err(err).Msg("failed to send PADS")
+		return err
+	}
+	return nil
+}
+
+func (ac *AccessConcentrator) handlePADT(padt *Packet) {
+	ac.sessionsMu.Lock()
+	session, ok := ac.sessions[padt.SessionID]
+	if ok {
+		delete(ac.sessions, padt.SessionID)
+		delete(ac.sessionsByMAC, session.pppoe.acMAC.String())
+	}
+	ac.sessionsMu.Unlock()
+	if !ok {
+		return
+	}
+	atomic.StoreUint32(session.pppoe.state, pppoeStateClosed)
+	session.cancel()
+	ac.allocator.Release(padt.SessionID)
+	ac.logger.Info().Uint16("SessionID", padt.SessionID).Msg("PPPoE session closed via PADT")
+}
+
+// newServerSession returns an acSession already in pppoeStateOpen,
+// representing the AC's side of a session bound to peerMAC/sessionID once
+// PADS is sent; it wires an lcp.PPP over the session's PPPoE transport, the
+// same way session.Supervisor wires one for a client session, so a caller
+// can register and drive LCP/IPCP (see AccessConcentrator.PPP) against it.
+// Canceling ctx (or the parent ctx passed to Serve) tears this session's PPP
+// goroutines down.
+func (ac *AccessConcentrator) newServerSession(ctx context.Context, peerMAC net.HardwareAddr, sessionID uint16) *acSession {
+	r := NewPPPoE(ac.conn, ac.logger)
+	r.acMAC = peerMAC // for the AC side, the "remote" is the subscriber
+	r.sessionID = sessionID
+	r.serverMode = true // frames arrive pre-demuxed via dispatchSessionFrame
+	atomic.StoreUint32(r.state, pppoeStateOpen)
+	sessCtx, cancel := context.WithCancel(ctx)
+	ppp := lcp.NewPPP(sessCtx, r, ac.logger)
+	return &acSession{pppoe: r, ppp: ppp, cancel: cancel}
+}
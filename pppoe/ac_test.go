@@ -0,0 +1,132 @@
+package pppoe
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestDefaultSessionAllocator(t *testing.T) {
+	a := newDefaultSessionAllocator()
+
+	id, err := a.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if id == 0 {
+		t.Fatal("Allocate returned reserved session ID 0")
+	}
+
+	id2, err := a.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if id2 == id {
+		t.Fatalf("Allocate returned the same ID twice: %d", id)
+	}
+
+	// Release frees the ID, but allocation stays sequential: the next
+	// Allocate moves on to the following ID rather than reusing it at once.
+	a.Release(id)
+	if a.used[id] {
+		t.Fatalf("Release(%d) left the ID marked used", id)
+	}
+	id3, err := a.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate after Release failed: %v", err)
+	}
+	if id3 != id2+1 {
+		t.Fatalf("Allocate after Release did not return the next sequential ID, got %d want %d", id3, id2+1)
+	}
+}
+
+// registerTestSession mimics the bookkeeping half of handlePADR - the half
+// that doesn't need a live etherconn.EtherConn to send PADS - so AC session
+// bookkeeping can be tested without root privilege or a real NIC.
+func registerTestSession(ac *AccessConcentrator, ctx context.Context, peerMAC net.HardwareAddr, id uint16) {
+	sess := ac.newServerSession(ctx, peerMAC, id)
+	ac.sessionsMu.Lock()
+	ac.sessions[id] = sess
+	ac.sessionsByMAC[peerMAC.String()] = id
+	ac.sessionsMu.Unlock()
+}
+
+func TestAccessConcentratorPPPAndSessionAccessors(t *testing.T) {
+	logger := zerolog.Nop()
+	ac := NewAccessConcentrator("testac", nil, &logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peerMAC := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	registerTestSession(ac, ctx, peerMAC, 7)
+
+	if _, ok := ac.Session(8); ok {
+		t.Fatal("Session(8) found a session that was never registered")
+	}
+	pppoeConn, ok := ac.Session(7)
+	if !ok {
+		t.Fatal("Session(7) not found after registerTestSession")
+	}
+	if !pppoeConn.RemoteMAC().Equal(peerMAC) {
+		t.Fatalf("Session(7).RemoteMAC() = %v, want %v", pppoeConn.RemoteMAC(), peerMAC)
+	}
+	if _, ok := ac.PPP(7); !ok {
+		t.Fatal("PPP(7) not found after registerTestSession")
+	}
+}
+
+func TestHandlePADTRemovesSession(t *testing.T) {
+	logger := zerolog.Nop()
+	ac := NewAccessConcentrator("testac", nil, &logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peerMAC := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	id, err := ac.allocator.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	registerTestSession(ac, ctx, peerMAC, id)
+
+	ac.handlePADT(&Packet{Code: CodePADT, SessionID: id})
+
+	if _, ok := ac.Session(id); ok {
+		t.Fatalf("session %d still present after handlePADT", id)
+	}
+	if _, ok := ac.sessionsByMAC[peerMAC.String()]; ok {
+		t.Fatal("sessionsByMAC still has an entry for the peer after handlePADT")
+	}
+
+	// handlePADT must release the session ID back to the allocator, not leak
+	// it, so it shows up as free again.
+	alloc := ac.allocator.(*defaultSessionAllocator)
+	alloc.mu.Lock()
+	_, stillUsed := alloc.used[id]
+	alloc.mu.Unlock()
+	if stillUsed {
+		t.Fatalf("session ID %d still marked used after handlePADT", id)
+	}
+}
+
+func TestTagValueOfType(t *testing.T) {
+	tags := []Tag{
+		&TagByteSlice{TagType: TagTypeACCookie, Value: []byte("cookie123")},
+		&TagString{TagByteSlice: &TagByteSlice{TagType: TagTypeServiceName, Value: []byte("svc")}},
+	}
+
+	v, ok := tagValueOfType(tags, TagTypeACCookie)
+	if !ok || string(v) != "cookie123" {
+		t.Fatalf("tagValueOfType(ACCookie) = %q, %v, want \"cookie123\", true", v, ok)
+	}
+
+	v, ok = tagValueOfType(tags, TagTypeServiceName)
+	if !ok || string(v) != "svc" {
+		t.Fatalf("tagValueOfType(ServiceName) = %q, %v, want \"svc\", true", v, ok)
+	}
+
+	if _, ok := tagValueOfType(tags, TagTypeHostUniq); ok {
+		t.Fatal("tagValueOfType found a tag type that isn't present")
+	}
+}
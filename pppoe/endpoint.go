@@ -0,0 +1,87 @@
+package pppoe
+
+import (
+	"fmt"
+	"github.com/gandalfast/zouppp/etherconn"
+	"net"
+)
+
+// L2Endpoint is the minimal surface pppoe needs from the underlying
+// etherconn peer endpoint. It lets pppoe depend on behavior instead of the
+// concrete etherconn.L2Endpoint type.
+//
+// The real fix for one PPPoE.ReadFrom scanning every packet's bytes for its
+// own session ID is AccessConcentrator.Serve: it is the only reader of the
+// shared conn and dispatches each session frame to its session by the
+// PPPoE session ID it already parsed, see dispatchSessionFrame. SessionIDer
+// below remains an extension point for etherconn itself demuxing by session
+// ID in the future, but nothing currently implements it.
+type L2Endpoint interface {
+	// SrcMAC is the peer's MAC address
+	SrcMAC() net.HardwareAddr
+	// DstMAC is the local MAC address the peer's traffic was addressed to
+	DstMAC() net.HardwareAddr
+	// VLANs returns the VLAN tag stack the endpoint was received/sent with
+	VLANs() []uint16
+	// ClearSrc resets any cached source info, so the endpoint can be reused
+	// for a different peer
+	ClearSrc()
+}
+
+// SessionIDer is optionally implemented by an L2Endpoint that etherconn has
+// already demultiplexed by PPPoE session ID; ReadFrom uses it in preference
+// to parsing the session ID out of the received bytes itself.
+type SessionIDer interface {
+	SessionID() (id uint16, ok bool)
+}
+
+// etherconnL2Endpoint adapts *etherconn.L2Endpoint to L2Endpoint; it
+// carries no VLAN or session-ID demux info.
+type etherconnL2Endpoint struct {
+	*etherconn.L2Endpoint
+}
+
+func (e *etherconnL2Endpoint) SrcMAC() net.HardwareAddr {
+	return e.HwAddr
+}
+
+func (e *etherconnL2Endpoint) DstMAC() net.HardwareAddr {
+	return nil
+}
+
+func (e *etherconnL2Endpoint) VLANs() []uint16 {
+	return nil
+}
+
+func (e *etherconnL2Endpoint) ClearSrc() {
+	e.HwAddr = nil
+}
+
+func wrapL2Endpoint(l2ep *etherconn.L2Endpoint) L2Endpoint {
+	return &etherconnL2Endpoint{L2Endpoint: l2ep}
+}
+
+// Endpoint represents a PPPoE endpont
+type Endpoint struct {
+	// L2EP is the associated EtherConn endpoint
+	L2EP L2Endpoint
+	// SessionID is the PPPoE session ID
+	SessionID uint16
+}
+
+// Network implenets net.Addr interface, always return "pppoe"
+func (pep Endpoint) Network() string {
+	return "pppoe"
+}
+
+// String implenets net.Addr interface, return "pppoe:<L2EP>:<SessionID>"
+func (pep Endpoint) String() string {
+	return fmt.Sprintf("pppoe:%v:%x", pep.L2EP.SrcMAC(), pep.SessionID)
+}
+
+func newPPPoEEndpoint(l2ep L2Endpoint, sid uint16) *Endpoint {
+	return &Endpoint{
+		L2EP:      l2ep,
+		SessionID: sid,
+	}
+}
@@ -2,11 +2,13 @@
 package pppoe
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/gandalfast/zouppp/etherconn"
+	"github.com/gandalfast/zouppp/lcp"
 	"github.com/rs/zerolog"
 	"net"
 	"sync"
@@ -25,10 +27,16 @@ type PPPoE struct {
 	cancelFunc  context.CancelFunc
 	debug       bool
 	recvChan    chan []byte
-	state       *uint32
-	logger      *zerolog.Logger
-	timeout     time.Duration
-	retry       int
+	// serverMode is true for a session created by
+	// AccessConcentrator.newServerSession; Receive then reads frames off
+	// recvChan, already demultiplexed by session ID from the AC's single
+	// Serve loop, instead of scanning every packet off conn itself.
+	serverMode bool
+	state      *uint32
+	closed     *uint32
+	logger     *zerolog.Logger
+	timeout    time.Duration
+	retry      int
 }
 
 const (
@@ -94,6 +102,7 @@ func NewPPPoE(conn *etherconn.EtherConn, logger *zerolog.Logger, options ...Modi
 	}
 	r.state = new(uint32)
 	*r.state = pppoeStateInitial
+	r.closed = new(uint32)
 	r.wg = new(sync.WaitGroup)
 	r.recvChan = make(chan []byte, recvChanDepth)
 	r.conn = conn
@@ -120,12 +129,22 @@ func (pppoe *PPPoE) SetDeadline(t time.Time) error {
 
 // LocalAddr return local Endpoint, see doc of Endpoint
 func (pppoe *PPPoE) LocalAddr() net.Addr {
-	return newPPPoEEndpoint(pppoe.conn.LocalAddr(), pppoe.sessionID)
+	return newPPPoEEndpoint(wrapL2Endpoint(pppoe.conn.LocalAddr()), pppoe.sessionID)
 }
 
-// Close implements net.PacketConn interface
+// Close implements net.PacketConn interface and lcp.Transport; it unblocks
+// any pending Receive. Close is idempotent: a session can be torn down via
+// more than one path at once (e.g. a caller closing it directly while
+// lcp.PPP's own context-done goroutine also closes its Transport), and only
+// the first call sends PADT.
 func (pppoe *PPPoE) Close() error {
-	if atomic.LoadUint32(pppoe.state) == pppoeStateOpen {
+	if !atomic.CompareAndSwapUint32(pppoe.closed, 0, 1) {
+		return nil
+	}
+	if pppoe.serverMode {
+		close(pppoe.recvChan)
+	}
+	if atomic.CompareAndSwapUint32(pppoe.state, pppoeStateOpen, pppoeStateClosed) {
 		pkt := pppoe.buildPADT()
 		pktbytes, err := pkt.Serialize()
 		if err != nil {
@@ -152,6 +171,17 @@ func (pppoe *PPPoE) buildPADI() *Packet {
 	return padi
 }
 
+// copyTagsOfType returns, in order, all tags in src whose type is one of
+// wanted; it is used to echo tags RFC2516 requires a reply to carry over
+// from the request it answers (e.g. Host-Uniq, Relay-Session-Id, AC-Cookie).
+func copyTagsOfType(src *Packet, wanted ...TagType) []Tag {
+	var out []Tag
+	for _, t := range wanted {
+		out = append(out, src.GetTag(t)...)
+	}
+	return out
+}
+
 func (pppoe *PPPoE) buildPADRWithPADO(pado *Packet) *Packet {
 	padr := new(Packet)
 	padr.Code = CodePADR
@@ -169,8 +199,7 @@ func (pppoe *PPPoE) buildPADRWithPADO(pado *Packet) *Packet {
 			padr.Tags = append(padr.Tags, t)
 		}
 	}
-	padr.Tags = append(padr.Tags, pado.GetTag(TagTypeACCookie)...)
-	padr.Tags = append(padr.Tags, pado.GetTag(TagTypeRelaySessionID)...)
+	padr.Tags = append(padr.Tags, copyTagsOfType(pado, TagTypeACCookie, TagTypeRelaySessionID)...)
 	return padr
 }
 
@@ -195,45 +224,53 @@ func (pppoe *PPPoE) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 
 }
 
-// ReadFrom implments net.PacketConn interface; only works after pppoe session is open
+// ReadFrom implments net.PacketConn interface; only works after pppoe session is open.
+// It is the single-session fallback used by a client (Dial) PPPoE, which is
+// the only reader of its conn; an AC server session instead gets its frames
+// already demultiplexed by session ID from AccessConcentrator.Serve, see
+// Receive and serverMode.
 func (pppoe *PPPoE) ReadFrom(buf []byte) (int, net.Addr, error) {
 	if atomic.LoadUint32(pppoe.state) != pppoeStateOpen {
 		return 0, nil, fmt.Errorf("pppoe is not open")
 	}
-	// var remotemac net.HardwareAddr
-	var l2ep *etherconn.L2Endpoint
+	var ep L2Endpoint
 	var err error
 	var n int
 	for {
-		n, l2ep, err = pppoe.conn.ReadPktFrom(buf)
+		var rawl2ep *etherconn.L2Endpoint
+		n, rawl2ep, err = pppoe.conn.ReadPktFrom(buf)
 		if err != nil {
 			return 0, nil, fmt.Errorf("failed to recv, %w", err)
 		}
 		if n < 6 {
 			continue
 		}
-		if l2ep.HwAddr.String() != pppoe.acMAC.String() {
+		ep = wrapL2Endpoint(rawl2ep)
+		if !bytes.Equal(ep.SrcMAC(), pppoe.acMAC) {
 			continue
 		}
 		if Code(buf[1]) != CodeSession {
 			continue
 		}
-		//TODO: change ehtherconn so that L2Endpoint become a interface, and so that pppoe sessionid could be included
-		if binary.BigEndian.Uint16(buf[2:4]) != pppoe.sessionID {
+		// If the underlying endpoint already demuxed by PPPoE session ID
+		// (an etherconn fan-out keyed on (peer MAC, session ID), see
+		// SessionIDer), trust that instead of parsing the session ID out
+		// of buf ourselves.
+		if sider, ok := ep.(SessionIDer); ok {
+			if sid, known := sider.SessionID(); known && sid != pppoe.sessionID {
+				continue
+			}
+		} else if binary.BigEndian.Uint16(buf[2:4]) != pppoe.sessionID {
 			continue
 		}
 		buf = append(buf[:0], buf[6:]...)
 		break
 	}
-	//return int(binary.BigEndian.Uint16(buf[4:6])), etherconn.NewL2EndpointFromMACVLAN(remotemac, pppoe.vlans), nil
-	return n - 6, pppoe.newRemotePPPoEP(l2ep.HwAddr), nil
+	return n - 6, pppoe.newRemotePPPoEP(ep), nil
 }
 
-func (pppoe *PPPoE) newRemotePPPoEP(mac net.HardwareAddr) *Endpoint {
-	l2ep := etherconn.L2Endpoint{
-		HwAddr: mac,
-	}
-	return newPPPoEEndpoint(&l2ep, pppoe.sessionID)
+func (pppoe *PPPoE) newRemotePPPoEP(ep L2Endpoint) *Endpoint {
+	return newPPPoEEndpoint(ep, pppoe.sessionID)
 }
 
 // getResponse return 1st rcvd PPPoE response as specified by code, along with remote mac
@@ -273,6 +310,75 @@ func (pppoe *PPPoE) GetLogger() *zerolog.Logger {
 	return pppoe.logger
 }
 
+// RemoteMAC returns the remote peer's MAC address once the session is open:
+// the Access Concentrator's MAC for a client session, or the subscriber's
+// MAC for a session opened by AccessConcentrator.
+func (pppoe *PPPoE) RemoteMAC() net.HardwareAddr {
+	return pppoe.acMAC
+}
+
+// dispatch delivers payload, a session frame AccessConcentrator.Serve has
+// already matched to this session by PPPoE session ID, to a pending
+// Receive; it is only used for serverMode sessions.
+func (pppoe *PPPoE) dispatch(ctx context.Context, payload []byte) {
+	select {
+	case pppoe.recvChan <- payload:
+	case <-ctx.Done():
+	}
+}
+
+// SessionID returns the PPPoE session ID, valid once the session is open.
+func (pppoe *PPPoE) SessionID() uint16 {
+	return pppoe.sessionID
+}
+
+// Send implements lcp.Transport, sending one PPP frame over the PPPoE
+// session; it's equivalent to WriteTo(b, nil).
+func (pppoe *PPPoE) Send(b []byte) error {
+	_, err := pppoe.WriteTo(b, nil)
+	return err
+}
+
+// Receive implements lcp.Transport, returning the next PPP frame received
+// over the PPPoE session; it returns an error once Close is called.
+func (pppoe *PPPoE) Receive() ([]byte, error) {
+	if pppoe.serverMode {
+		// Already demultiplexed by session ID on AccessConcentrator's single
+		// Serve loop (see AccessConcentrator.dispatchSessionFrame); no need
+		// to scan frames off conn ourselves here.
+		b, ok := <-pppoe.recvChan
+		if !ok {
+			return nil, fmt.Errorf("pppoe transport is closed")
+		}
+		return b, nil
+	}
+	buf := make([]byte, lcp.MaxPPPMsgSize)
+	for {
+		if atomic.LoadUint32(pppoe.closed) == 1 {
+			return nil, fmt.Errorf("pppoe transport is closed")
+		}
+		pppoe.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		n, _, err := pppoe.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, etherconn.ErrTimeOut) {
+				continue
+			}
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// MTU implements lcp.Transport
+func (pppoe *PPPoE) MTU() int {
+	return lcp.MaxPPPMsgSize
+}
+
+// PeerName implements lcp.Transport
+func (pppoe *PPPoE) PeerName() string {
+	return fmt.Sprintf("%v/%04x", pppoe.acMAC, pppoe.sessionID)
+}
+
 // Dial complets a full PPPoE discovery exchange (PADI/PADO/PADR/PADS)
 func (pppoe *PPPoE) Dial(ctx context.Context) error {
 	//build PADI
@@ -309,28 +415,3 @@ func (pppoe *PPPoE) Dial(ctx context.Context) error {
 	_, pppoe.cancelFunc = context.WithCancel(ctx)
 	return nil
 }
-
-// Endpoint represents a PPPoE endpont
-type Endpoint struct {
-	// L2EP is the associated EtherConn's L2Endpoint
-	L2EP *etherconn.L2Endpoint
-	// SessionId is the PPPoE session ID
-	SessionID uint16
-}
-
-// Network implenets net.Addr interface, always return "pppoe"
-func (pep Endpoint) Network() string {
-	return "pppoe"
-}
-
-// String implenets net.Addr interface, return "pppoe:<L2EP>:<SessionID>"
-func (pep Endpoint) String() string {
-	return fmt.Sprintf("pppoe:%v:%x", pep.L2EP.String(), pep.SessionID)
-}
-
-func newPPPoEEndpoint(l2ep *etherconn.L2Endpoint, sid uint16) *Endpoint {
-	return &Endpoint{
-		L2EP:      l2ep,
-		SessionID: sid,
-	}
-}
@@ -0,0 +1,55 @@
+package pppoe
+
+import (
+	"context"
+	"testing"
+)
+
+// TestCloseIdempotent checks that Close can be called more than once on the
+// same *PPPoE, as happens when more than one teardown path closes it (see
+// session.Supervisor.teardown and lcp.PPP's context-done goroutine), and
+// that every call returns nil. The session here is never opened, so no PADT
+// is sent by either call.
+func TestCloseIdempotent(t *testing.T) {
+	p := &PPPoE{
+		state:  new(uint32),
+		closed: new(uint32),
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("first Close failed: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+}
+
+// TestServerModeDispatch verifies a serverMode session's Receive returns
+// whatever AccessConcentrator.Serve hands it via dispatch, without ever
+// scanning a conn, and that Close unblocks a pending Receive.
+func TestServerModeDispatch(t *testing.T) {
+	p := &PPPoE{
+		state:      new(uint32),
+		closed:     new(uint32),
+		recvChan:   make(chan []byte, 1),
+		serverMode: true,
+	}
+
+	want := []byte{1, 2, 3}
+	p.dispatch(context.Background(), want)
+
+	got, err := p.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("Receive returned %v, want %v", got, want)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := p.Receive(); err == nil {
+		t.Fatal("Receive after Close should have returned an error")
+	}
+}
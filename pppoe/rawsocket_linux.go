@@ -0,0 +1,244 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/gandalfast/zouppp/lcp"
+	"golang.org/x/sys/unix"
+	"net"
+	"sync"
+	"unsafe"
+)
+
+// RawSocketTransport implements lcp.Transport by speaking PPPoE session
+// frames (RFC2516) directly over an AF_PACKET socket, without going through
+// etherconn.EtherConn; it's meant for low-overhead single-session use where
+// etherconn's demux/fan-out machinery for many sessions on one NIC isn't
+// needed. The discovery exchange (PADI/PADO/PADR/PADS) that learns the
+// AC's MAC and session ID must already have happened, e.g. via a regular
+// PPPoE/EtherConn pair, before a RawSocketTransport is created.
+type RawSocketTransport struct {
+	fd        int
+	closeFD   int // eventfd signaled by Close to unblock a pending Receive
+	ifIndex   int
+	localMAC  net.HardwareAddr
+	peerMAC   net.HardwareAddr
+	sessionID uint16
+	closeOnce sync.Once
+}
+
+// NewRawSocketTransport opens a PPPoE-session-only AF_PACKET socket on
+// ifName, bound to the session identified by peerMAC/sessionID.
+func NewRawSocketTransport(ifName string, localMAC, peerMAC net.HardwareAddr, sessionID uint16) (*RawSocketTransport, error) {
+	ifi, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up interface %v, %w", ifName, err)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(EtherTypePPPoESession)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AF_PACKET socket, %w", err)
+	}
+	addr := unix.SockaddrLinklayer{
+		Protocol: htons(EtherTypePPPoESession),
+		Ifindex:  ifi.Index,
+	}
+	if err := unix.Bind(fd, &addr); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind AF_PACKET socket to %v, %w", ifName, err)
+	}
+	// Non-blocking so Receive's poll/recvfrom pair never parks inside the
+	// kernel past the point Close has asked it to stop.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to set AF_PACKET socket non-blocking, %w", err)
+	}
+	closeFD, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to create close eventfd, %w", err)
+	}
+	return &RawSocketTransport{
+		fd:        fd,
+		closeFD:   closeFD,
+		ifIndex:   ifi.Index,
+		localMAC:  localMAC,
+		peerMAC:   peerMAC,
+		sessionID: sessionID,
+	}, nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8&0xff00 | v>>8&0x00ff
+}
+
+func macEqual(b []byte, mac net.HardwareAddr) bool {
+	return len(b) >= 6 && net.HardwareAddr(b[:6]).String() == mac.String()
+}
+
+// Send implements lcp.Transport
+func (t *RawSocketTransport) Send(b []byte) error {
+	pkt := &Packet{Code: CodeSession, SessionID: t.sessionID, Payload: b}
+	pppoeBytes, err := pkt.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize pppoe pkt, %w", err)
+	}
+	frame := make([]byte, 14+len(pppoeBytes))
+	copy(frame[0:6], t.peerMAC)
+	copy(frame[6:12], t.localMAC)
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypePPPoESession)
+	copy(frame[14:], pppoeBytes)
+
+	addr := unix.SockaddrLinklayer{
+		Protocol: htons(EtherTypePPPoESession),
+		Ifindex:  t.ifIndex,
+		Halen:    6,
+	}
+	copy(addr.Addr[:6], t.peerMAC)
+	return unix.Sendto(t.fd, frame, 0, &addr)
+}
+
+// mmsghdr mirrors struct mmsghdr from <bits/socket.h>: an embedded msghdr
+// plus the byte count the kernel reports back for that message. unix.Msghdr
+// itself is exactly the C struct msghdr layout on linux/amd64, so this is
+// safe to hand to SYS_SENDMMSG via unsafe.Pointer. golang.org/x/sys/unix
+// has no higher-level sendmmsg(2) wrapper, so this builds the array by hand
+// the same way WireGuard's conn package does for its batched UDP sends.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+	_   [4]byte
+}
+
+// SendBatch implements lcp.BatchTransport, writing every frame in batch to
+// the peer with a single sendmmsg(2) syscall instead of one Sendto per frame.
+func (t *RawSocketTransport) SendBatch(batch [][]byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	addr := unix.SockaddrLinklayer{
+		Protocol: htons(EtherTypePPPoESession),
+		Ifindex:  t.ifIndex,
+		Halen:    6,
+	}
+	copy(addr.Addr[:6], t.peerMAC)
+	rsa, sockAddrLen, err := sockaddrLinklayerToRaw(&addr)
+	if err != nil {
+		return fmt.Errorf("failed to build destination sockaddr, %w", err)
+	}
+
+	iovs := make([]unix.Iovec, len(batch))
+	msgs := make([]mmsghdr, len(batch))
+	for i, b := range batch {
+		pkt := &Packet{Code: CodeSession, SessionID: t.sessionID, Payload: b}
+		pppoeBytes, err := pkt.Serialize()
+		if err != nil {
+			return fmt.Errorf("failed to serialize pppoe pkt, %w", err)
+		}
+		frame := make([]byte, 14+len(pppoeBytes))
+		copy(frame[0:6], t.peerMAC)
+		copy(frame[6:12], t.localMAC)
+		binary.BigEndian.PutUint16(frame[12:14], EtherTypePPPoESession)
+		copy(frame[14:], pppoeBytes)
+
+		iovs[i].Base = &frame[0]
+		iovs[i].SetLen(len(frame))
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(rsa))
+		msgs[i].hdr.Namelen = sockAddrLen
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.Iovlen = 1
+	}
+
+	for sent := 0; sent < len(msgs); {
+		n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(t.fd),
+			uintptr(unsafe.Pointer(&msgs[sent])), uintptr(len(msgs)-sent), 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("sendmmsg failed, %w", errno)
+		}
+		if n == 0 {
+			return fmt.Errorf("sendmmsg sent 0 of %d remaining messages", len(msgs)-sent)
+		}
+		sent += int(n)
+	}
+	return nil
+}
+
+func sockaddrLinklayerToRaw(addr *unix.SockaddrLinklayer) (*unix.RawSockaddrLinklayer, uint32, error) {
+	rsa := &unix.RawSockaddrLinklayer{
+		Family:   unix.AF_PACKET,
+		Protocol: addr.Protocol,
+		Ifindex:  int32(addr.Ifindex),
+		Hatype:   addr.Hatype,
+		Pkttype:  addr.Pkttype,
+		Halen:    addr.Halen,
+	}
+	rsa.Addr = addr.Addr
+	return rsa, uint32(unsafe.Sizeof(*rsa)), nil
+}
+
+// Receive implements lcp.Transport. It polls t.fd alongside closeFD so that
+// Close (which signals closeFD) reliably unblocks a pending Receive even
+// though t.fd itself is non-blocking and never parks in the kernel.
+func (t *RawSocketTransport) Receive() ([]byte, error) {
+	buf := make([]byte, lcp.MaxPPPMsgSize+14)
+	pollFDs := []unix.PollFd{
+		{Fd: int32(t.fd), Events: unix.POLLIN},
+		{Fd: int32(t.closeFD), Events: unix.POLLIN},
+	}
+	for {
+		pollFDs[0].Revents = 0
+		pollFDs[1].Revents = 0
+		if _, err := unix.Poll(pollFDs, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return nil, fmt.Errorf("failed to poll AF_PACKET socket, %w", err)
+		}
+		if pollFDs[1].Revents&unix.POLLIN != 0 {
+			return nil, fmt.Errorf("raw socket transport closed")
+		}
+		n, _, err := unix.Recvfrom(t.fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				continue
+			}
+			return nil, fmt.Errorf("failed to recv from AF_PACKET socket, %w", err)
+		}
+		if n < 14+6 || !macEqual(buf[6:12], t.peerMAC) {
+			continue
+		}
+		pkt := new(Packet)
+		if err := pkt.Parse(buf[14:n]); err != nil {
+			continue
+		}
+		if pkt.Code != CodeSession || pkt.SessionID != t.sessionID {
+			continue
+		}
+		return pkt.Payload, nil
+	}
+}
+
+// MTU implements lcp.Transport
+func (t *RawSocketTransport) MTU() int {
+	return lcp.MaxPPPMsgSize
+}
+
+// Close implements lcp.Transport. It signals closeFD before closing the
+// sockets so a Receive blocked in Poll wakes up via closeFD instead of
+// racing the fd's closure.
+func (t *RawSocketTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		one := make([]byte, 8)
+		binary.LittleEndian.PutUint64(one, 1)
+		_, _ = unix.Write(t.closeFD, one)
+		err = unix.Close(t.fd)
+		_ = unix.Close(t.closeFD)
+	})
+	return err
+}
+
+// PeerName implements lcp.Transport
+func (t *RawSocketTransport) PeerName() string {
+	return fmt.Sprintf("%v/%04x", t.peerMAC, t.sessionID)
+}
@@ -0,0 +1,294 @@
+// Package session provides a Supervisor that multiplexes many concurrent
+// PPPoE+PPP sessions over one or more etherconn handles, collapsing the
+// pppoe.NewPPPoE -> Dial -> lcp.NewPPP -> Register -> datapath.NewTUNIf
+// wiring callers previously had to do by hand for every session.
+package session
+
+import (
+	"context"
+	"fmt"
+	"github.com/gandalfast/zouppp/datapath"
+	"github.com/gandalfast/zouppp/etherconn"
+	"github.com/gandalfast/zouppp/lcp"
+	"github.com/gandalfast/zouppp/pppoe"
+	"github.com/rs/zerolog"
+	"net"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of lifecycle Event emitted by a Supervisor.
+type EventType int
+
+const (
+	// SessionUp is emitted once a session's PPPoE+PPP session is established
+	SessionUp EventType = iota
+	// SessionDown is emitted once a session terminates, for any reason
+	SessionDown
+	// Auth is reserved for callers driving PPP authentication (PAP/CHAP) to
+	// report completion; Supervisor itself does not run LCP auth
+	Auth
+	// IPCPComplete is reserved for callers driving IPCP negotiation to
+	// report assigned addresses; Supervisor itself does not run IPCP
+	IPCPComplete
+)
+
+// Event is a lifecycle notification about one session managed by a Supervisor.
+type Event struct {
+	Type      EventType
+	SessionID uint16
+	PeerMAC   net.HardwareAddr
+	Err       error // set on SessionDown when termination was due to an error
+}
+
+// PeerInfo is a snapshot of one session's state, returned by Supervisor.Peers.
+type PeerInfo struct {
+	PeerMAC    net.HardwareAddr
+	SessionID  uint16
+	MRU        uint16
+	AssignedV4 net.IP
+	AssignedV6 net.IP
+	AuthUser   string
+	Up         time.Time
+	// TXBytes and RXBytes are the total PPP-framed bytes sent/received over
+	// this session's PPP instance so far
+	TXBytes uint64
+	RXBytes uint64
+}
+
+// Config describes one session to add to a Supervisor via AddSession.
+type Config struct {
+	// Conn is the etherconn.EtherConn used for PPPoE discovery and the PPP session
+	Conn *etherconn.EtherConn
+	// ServiceName is the PPPoE Service-Name requested in PADI/PADR
+	ServiceName string
+	// IfName is the TUN interface name created for this session
+	IfName string
+	// V4Addr and V6Addr are the local/peer addresses installed on the TUN
+	// interface for each IP family; until a caller drives IPCP negotiation
+	// on top of this package, these must be supplied up front (e.g. from a
+	// prior negotiation or static config)
+	V4Addr, V6Addr datapath.AddrConfig
+	// MRU is used as the TUN interface's MTU
+	MRU uint16
+	// AuthUser records who/what this session authenticated as, for PeerInfo only
+	AuthUser string
+	// PPPoEOptions, PPPOptions and TUNOptions pass modifiers through to the
+	// underlying pppoe.NewPPPoE, lcp.NewPPP and datapath.NewTUNIf calls
+	PPPoEOptions []pppoe.Modifier
+	PPPOptions   []lcp.Option
+	TUNOptions   []datapath.Modifier
+}
+
+type session struct {
+	cfg    Config
+	pppoe  *pppoe.PPPoE
+	ppp    *lcp.PPP
+	tun    *datapath.TUNInterface
+	cancel context.CancelFunc
+	up     time.Time
+}
+
+// Supervisor owns and multiplexes many concurrent PPPoE+PPP sessions,
+// exposing their lifecycle on Events and a live snapshot via Peers.
+type Supervisor struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	logger      *zerolog.Logger
+	maxSessions int
+	retry       int
+	retryDelay  time.Duration
+	events      chan Event
+	mu          sync.RWMutex
+	sessions    map[uint16]*session
+}
+
+const (
+	_eventChanDepth    = 128
+	_defaultRetry      = 1
+	_defaultRetryDelay = time.Second
+)
+
+// Modifier customizes a Supervisor created by NewSupervisor
+type Modifier func(s *Supervisor)
+
+// WithMaxSessions caps the number of concurrently-open sessions; AddSession
+// fails once the cap is reached. 0 (the default) means unlimited.
+func WithMaxSessions(n int) Modifier {
+	return func(s *Supervisor) { s.maxSessions = n }
+}
+
+// WithRetry sets how many times in total AddSession attempts PPPoE.Dial
+// before giving up, and the delay after each failed attempt.
+func WithRetry(attempts int, delay time.Duration) Modifier {
+	return func(s *Supervisor) {
+		if attempts > 0 {
+			s.retry = attempts
+		}
+		if delay > 0 {
+			s.retryDelay = delay
+		}
+	}
+}
+
+// NewSupervisor returns a new Supervisor bound to ctx; canceling ctx tears
+// down every session the Supervisor owns.
+func NewSupervisor(ctx context.Context, logger *zerolog.Logger, options ...Modifier) *Supervisor {
+	s := new(Supervisor)
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.logger = logger
+	s.retry = _defaultRetry
+	s.retryDelay = _defaultRetryDelay
+	s.events = make(chan Event, _eventChanDepth)
+	s.sessions = make(map[uint16]*session)
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Events returns the channel Supervisor lifecycle notifications are sent on.
+func (s *Supervisor) Events() <-chan Event {
+	return s.events
+}
+
+// AddSession dials a new PPPoE+PPP session per cfg, retrying per WithRetry,
+// then brings up a TUN interface for it. It returns once the session is
+// open, having emitted SessionUp on Events; SessionDown is emitted once the
+// session is later torn down via RemoveSession or Supervisor shutdown, or
+// once its PPP instance exits on its own (see watchSession).
+func (s *Supervisor) AddSession(cfg Config) error {
+	s.mu.Lock()
+	if s.maxSessions > 0 && len(s.sessions) >= s.maxSessions {
+		s.mu.Unlock()
+		return fmt.Errorf("max sessions (%d) reached", s.maxSessions)
+	}
+	s.mu.Unlock()
+
+	pppoeConn := pppoe.NewPPPoE(cfg.Conn, s.logger, cfg.PPPoEOptions...)
+	var err error
+	for i := 0; i < s.retry; i++ {
+		if err = pppoeConn.Dial(s.ctx); err == nil {
+			break
+		}
+		s.logger.Warn().Err(err).Int("attempt", i+1).Msg("PPPoE dial failed")
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(s.retryDelay):
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to dial PPPoE session, %w", err)
+	}
+
+	sessCtx, cancel := context.WithCancel(s.ctx)
+	pppProto := lcp.NewPPP(sessCtx, pppoeConn, s.logger, cfg.PPPOptions...)
+	tun, err := datapath.NewTUNIf(sessCtx, pppProto, cfg.IfName, cfg.V4Addr, cfg.V6Addr, cfg.MRU, cfg.TUNOptions...)
+	if err != nil {
+		cancel()
+		_ = pppoeConn.Close()
+		return fmt.Errorf("failed to create TUN interface, %w", err)
+	}
+
+	sid := pppoeConn.SessionID()
+	sess := &session{cfg: cfg, pppoe: pppoeConn, ppp: pppProto, tun: tun, cancel: cancel, up: time.Now()}
+
+	s.mu.Lock()
+	s.sessions[sid] = sess
+	s.mu.Unlock()
+
+	s.emit(Event{Type: SessionUp, SessionID: sid, PeerMAC: pppoeConn.RemoteMAC()})
+	go s.watchSession(sid, sess)
+	return nil
+}
+
+// watchSession waits for sess's PPP instance to exit on its own (transport
+// error, peer PADT, ...) and tears the session down if it is still present
+// in s.sessions at that point. RemoveSession/Shutdown also cancel sess's
+// context, which closes its PPP the same way, so watchSession and those
+// paths race to remove sid from s.sessions; whichever wins runs teardown,
+// the other is a no-op, so SessionDown is only ever emitted once.
+func (s *Supervisor) watchSession(sid uint16, sess *session) {
+	<-sess.ppp.Done()
+
+	s.mu.Lock()
+	cur, ok := s.sessions[sid]
+	if !ok || cur != sess {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, sid)
+	s.mu.Unlock()
+
+	s.teardown(sid, sess, sess.ppp.Err())
+}
+
+// RemoveSession tears down the session identified by sessionID: it sends a
+// PADT, cancels the session's goroutines, and emits SessionDown. It is a
+// no-op if no such session exists.
+func (s *Supervisor) RemoveSession(sessionID uint16) {
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	if ok {
+		delete(s.sessions, sessionID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	s.teardown(sessionID, sess, nil)
+}
+
+func (s *Supervisor) teardown(sessionID uint16, sess *session, cause error) {
+	peerMAC := sess.pppoe.RemoteMAC()
+	_ = sess.pppoe.Close()
+	sess.cancel()
+	s.emit(Event{Type: SessionDown, SessionID: sessionID, PeerMAC: peerMAC, Err: cause})
+}
+
+// Peers returns a snapshot of every session currently tracked by the Supervisor.
+func (s *Supervisor) Peers() []PeerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make([]PeerInfo, 0, len(s.sessions))
+	for sid, sess := range s.sessions {
+		peers = append(peers, PeerInfo{
+			PeerMAC:    sess.pppoe.RemoteMAC(),
+			SessionID:  sid,
+			MRU:        sess.cfg.MRU,
+			AssignedV4: sess.cfg.V4Addr.Local,
+			AssignedV6: sess.cfg.V6Addr.Local,
+			AuthUser:   sess.cfg.AuthUser,
+			Up:         sess.up,
+			TXBytes:    sess.ppp.TXBytes(),
+			RXBytes:    sess.ppp.RXBytes(),
+		})
+	}
+	return peers
+}
+
+// Shutdown tears down every session owned by the Supervisor, emitting
+// SessionDown for each, then closes Events without waiting for the caller to
+// drain it; events already queued can still be read from the closed
+// channel. The Supervisor must not be used afterward.
+func (s *Supervisor) Shutdown() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[uint16]*session)
+	s.mu.Unlock()
+
+	for sid, sess := range sessions {
+		s.teardown(sid, sess, nil)
+	}
+	s.cancel()
+	close(s.events)
+}
+
+func (s *Supervisor) emit(ev Event) {
+	select {
+	case s.events <- ev:
+	default:
+		s.logger.Warn().Msg("Supervisor events channel full, dropping event")
+	}
+}
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gandalfast/zouppp/lcp"
+	"github.com/gandalfast/zouppp/pppoe"
+	"github.com/rs/zerolog"
+)
+
+// fakeTransport is a minimal lcp.Transport whose Receive blocks until either
+// a frame is fed in or Close is called, so lcp.PPP's recv loop (and thus
+// Done/Err) can be driven deterministically from a test without a real
+// socket.
+type fakeTransport struct {
+	recvCh    chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{recvCh: make(chan []byte), closed: make(chan struct{})}
+}
+
+func (f *fakeTransport) Send([]byte) error { return nil }
+
+func (f *fakeTransport) Receive() ([]byte, error) {
+	select {
+	case b, ok := <-f.recvCh:
+		if !ok {
+			return nil, io.EOF
+		}
+		return b, nil
+	case <-f.closed:
+		return nil, io.EOF
+	}
+}
+
+func (f *fakeTransport) MTU() int { return 1500 }
+
+func (f *fakeTransport) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeTransport) PeerName() string { return "fake" }
+
+// newTestSession inserts a session directly into sup's session map, bypassing
+// AddSession's real PPPoE dial/TUN setup so RemoveSession/Peers/Shutdown/
+// watchSession bookkeeping can be exercised without a root-privileged
+// network stack. The session's PPP instance runs over a fakeTransport
+// instead of the pppoeConn, so its death can be simulated directly.
+func newTestSession(sup *Supervisor, sid uint16) (*session, *fakeTransport) {
+	logger := zerolog.Nop()
+	pppoeConn := pppoe.NewPPPoE(nil, &logger)
+	sessCtx, cancel := context.WithCancel(sup.ctx)
+	transport := newFakeTransport()
+	ppp := lcp.NewPPP(sessCtx, transport, &logger)
+	sess := &session{
+		cfg:    Config{MRU: 1492},
+		pppoe:  pppoeConn,
+		ppp:    ppp,
+		cancel: cancel,
+		up:     time.Now(),
+	}
+
+	sup.mu.Lock()
+	sup.sessions[sid] = sess
+	sup.mu.Unlock()
+	return sess, transport
+}
+
+func TestRemoveSessionEmitsSessionDown(t *testing.T) {
+	logger := zerolog.Nop()
+	sup := NewSupervisor(context.Background(), &logger)
+	newTestSession(sup, 42)
+
+	sup.RemoveSession(42)
+
+	select {
+	case ev := <-sup.Events():
+		if ev.Type != SessionDown || ev.SessionID != 42 {
+			t.Fatalf("got event %+v, want SessionDown for session 42", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for SessionDown event")
+	}
+
+	if peers := sup.Peers(); len(peers) != 0 {
+		t.Fatalf("Peers() = %v, want empty after RemoveSession", peers)
+	}
+}
+
+func TestRemoveSessionUnknownIDIsNoop(t *testing.T) {
+	logger := zerolog.Nop()
+	sup := NewSupervisor(context.Background(), &logger)
+
+	sup.RemoveSession(99) // must not panic or emit anything
+
+	select {
+	case ev := <-sup.Events():
+		t.Fatalf("unexpected event %+v for unknown session", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShutdownTearsDownAllSessionsAndClosesEvents(t *testing.T) {
+	logger := zerolog.Nop()
+	sup := NewSupervisor(context.Background(), &logger)
+	newTestSession(sup, 1)
+	newTestSession(sup, 2)
+
+	sup.Shutdown()
+
+	seen := map[uint16]bool{}
+	for ev := range sup.Events() {
+		if ev.Type == SessionDown {
+			seen[ev.SessionID] = true
+		}
+	}
+	if !seen[1] || !seen[2] {
+		t.Fatalf("Shutdown did not emit SessionDown for both sessions: %v", seen)
+	}
+}
+
+// TestSessionDeathEmitsSessionDown verifies that a session whose PPP exits
+// on its own - a transport error or peer-initiated teardown, not a caller
+// calling RemoveSession/Shutdown - is still torn down and reported via
+// watchSession/SessionDown, per the SessionDown doc comment's "for any
+// reason" promise.
+func TestSessionDeathEmitsSessionDown(t *testing.T) {
+	logger := zerolog.Nop()
+	sup := NewSupervisor(context.Background(), &logger)
+	_, transport := newTestSession(sup, 7)
+
+	// Simulate the transport dying out from under the session, as if the
+	// peer sent a PADT or the underlying socket errored.
+	_ = transport.Close()
+
+	select {
+	case ev := <-sup.Events():
+		if ev.Type != SessionDown || ev.SessionID != 7 {
+			t.Fatalf("got event %+v, want SessionDown for session 7", ev)
+		}
+		if ev.Err == nil {
+			t.Fatal("SessionDown.Err is nil for an unsupervised transport death")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for SessionDown event")
+	}
+
+	if peers := sup.Peers(); len(peers) != 0 {
+		t.Fatalf("Peers() = %v, want empty after session death", peers)
+	}
+}
+
+func TestPeersReportsByteCounters(t *testing.T) {
+	logger := zerolog.Nop()
+	sup := NewSupervisor(context.Background(), &logger)
+	_, transport := newTestSession(sup, 1)
+
+	if peers := sup.Peers(); peers[0].TXBytes != 0 || peers[0].RXBytes != 0 {
+		t.Fatalf("got non-zero byte counters on an idle session: %+v", peers[0])
+	}
+
+	frame := []byte{0, 0, 1, 2, 3}
+	transport.recvCh <- frame
+
+	deadline := time.After(time.Second)
+	for {
+		if peers := sup.Peers(); len(peers) == 1 && peers[0].RXBytes == uint64(len(frame)) {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for RXBytes to reflect the received frame")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}